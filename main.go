@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -9,15 +11,24 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"github.com/knight42/kt/pkg/completion"
+	"github.com/knight42/kt/pkg/controller"
 	"github.com/knight42/kt/pkg/log"
 	"github.com/knight42/kt/pkg/version"
 )
 
-func checkError(err error) {
-	if err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+// exitMatchLimit is the exit code when the query matched more lines than
+// allowed by --fail-on-match.
+const exitMatchLimit = 3
+
+// exit reports err and exits with the exit code for it.
+func exit(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	var mle *controller.MatchLimitError
+	if errors.As(err, &mle) {
+		mle.WriteReport(os.Stderr)
+		os.Exit(exitMatchLimit)
 	}
+	os.Exit(1)
 }
 
 func main() {
@@ -42,19 +53,35 @@ func main() {
  # Filter pods by name or regexp
  kt 'foo'
  kt 'foo-\w+'`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if printVersion {
-				checkError(version.Run())
-				return
+				return version.Run()
 			}
 			if len(shell) > 0 {
-				checkError(completion.Generate(cmd, shell))
-				return
+				return completion.Generate(cmd, shell)
+			}
+			if o.explainQuery {
+				if err := o.completeQuery(); err != nil {
+					return err
+				}
+				var in io.Reader
+				if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+					in = os.Stdin
+				}
+				return o.Explain(in, os.Stdout)
 			}
-			checkError(o.Complete(f, args))
-			checkError(o.Run(cmd))
+			o.beforeContextSet = cmd.Flags().Changed("before-context")
+			o.afterContextSet = cmd.Flags().Changed("after-context")
+			if err := o.Complete(f, args); err != nil {
+				return err
+			}
+			// Errors of Run, like exceeding --fail-on-match, are not
+			// usage errors.
+			cmd.SilenceUsage = true
+			return o.Run(cmd)
 		},
 		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
 	}
 	flags := cmd.Flags()
 	flags.StringVar(f.KubeConfig, "kubeconfig", *f.KubeConfig, "Path to the kubeconfig file to use for CLI requests.")
@@ -71,14 +98,39 @@ func main() {
 	flags.StringVarP(&o.container, "container", "c", o.container, "Regular expression to match container names.")
 	flags.Int64Var(&o.tail, "tail", 10, "Lines of recent log file to display. Defaults to 10. If set to 0 it will return all logs.")
 	flags.BoolVar(&o.timestamps, "timestamps", o.timestamps, "Include timestamps on each line in the log output")
+	flags.StringVar(&o.tsFormat, "timestamp-format", "", "Format of timestamps, one of: local|relative|delta or a Go time layout, e.g. 15:04:05.000. local is the local time, relative the time since kt started, e.g. +1.204s, and delta the time since the previous line. Implies --timestamps.")
+	flags.BoolVar(&o.tsInPrefix, "timestamp-in-prefix", false, "Print timestamps before the pod/container prefix rather than before the log. Implies --timestamps.")
 	flags.StringVar(&o.prefix, "prefix", "auto", "When to show the pod/container prefix. One of: auto|always|never")
+	flags.StringVar(&o.prefixFormat, "prefix-format", "", "Render the prefix with a Go template, with the same data and functions as -o template, e.g. '{{.Namespace}}/{{.Pod|short}}@{{.Node}} r{{.Revision}} '. .Labels are the labels of the pod and .Revision the revision of its Deployment.")
+	flags.StringSliceVar(&o.prefixLabels, "prefix-labels", nil, "Append the values of these comma-separated pod labels to the prefix, e.g. app,version.")
 	flags.StringVar(&o.sinceTime, "since-time", o.sinceTime, "Only return logs after a specific date (RFC3339). Only one of since-time / since may be used.")
 	flags.StringVar(&o.color, "color", "auto", "Colorize the output. One of: auto|always|never|on|off|yes|no")
 	flags.DurationVar(&o.sinceSeconds, "since", o.sinceSeconds, "Only return logs newer than a relative duration like 5s, 2m, or 3h. Defaults to all logs. Only one of since-time / since may be used.")
 	flags.StringVar(&o.nodeName, "node-name", "", "The name of the node that pods running on")
-	flags.StringVarP(&o.queryStr, "query", "q", "", "Filter logs by query DSL (e.g. 'error and fatal', 'err or warn', '\"error code\" and timeout')")
+	flags.StringVarP(&o.queryStr, "query", "q", "", "Filter logs by query DSL (e.g. 'error and fatal', 'err or warn', 'error and not healthcheck', '\"error code\" and timeout')")
+	flags.StringVar(&o.queryFile, "query-file", "", "Filter logs by the query DSL in a file, which may contain # comments and macros defined with 'let NAME = expr' and referenced as $NAME.")
+	flags.BoolVar(&o.explainQuery, "explain-query", false, "Print the query fully parenthesized and its terms, then exit. Lines piped to stdin are tested against the query, showing which subexpressions match them.")
+	flags.IntVar(&o.failOnMatch, "fail-on-match", -1, "Exit with code 3 and a report of the matches once the query matched more than this many lines. Without a value, exit on the first match. A count must be passed as --fail-on-match=N.")
+	flags.Lookup("fail-on-match").NoOptDefVal = "0"
+	flags.DurationVar(&o.timeout, "timeout", 0, "Stop tailing and exit after this duration, e.g. 10m. Zero means no timeout.")
+	flags.StringVar(&o.untilStr, "until", "", "Stop tailing and exit with 0 once a line logged after kt started matches the query DSL, regardless of --query, --exclude, --level and the other filters. Exit with 1 if kt stops first, e.g. on --timeout.")
+	flags.IntVar(&o.untilPods, "until-pods", 1, "With --until, wait until this many pods have each logged a matching line.")
+	flags.StringVarP(&o.output, "output", "o", controller.OutputText, "Output format, one of: text|json|template=TEMPLATE. json prints each log as a JSON object with its metadata and the query terms it contains. template renders each log with a Go template, e.g. template='{{.Time.Format \"15:04:05\"}} {{.Pod|short}} {{.Message}}'.")
+	flags.StringSliceVar(&o.fieldNames, "fields", nil, "Print only the values of these comma-separated fields of JSON or logfmt logs in order, e.g. ts,level,msg,err. Missing fields are printed as -, other logs as they are.")
+	flags.BoolVar(&o.group, "group", false, "Join the lines of multi-line logs of each container, like Java stack traces, Python tracebacks and Go panics, into single records which are queried and printed as a whole. A line continues the previous one if it is indented or starts with \"Caused by:\".")
+	flags.StringVar(&o.groupStart, "group-start", "", "Join lines into records which start with lines matching this regular expression instead, e.g. '^\\d{4}-\\d{2}-\\d{2} '. Implies --group.")
+	flags.StringVar(&o.jsonPretty, "json-pretty", "", "Render logs which are JSON objects, one of: compact|indent. compact prints the level and message followed by the other fields as key=value, indent prints indented JSON. Other logs are printed as they are.")
+	flags.Lookup("json-pretty").NoOptDefVal = controller.JSONPrettyCompact
+	flags.StringArrayVarP(&o.excludeStrs, "exclude", "x", nil, "Drop logs matching the query DSL before applying --query. Can be repeated (e.g. -x healthz -x 'level=debug')")
+	flags.StringVar(&o.levelStr, "level", o.levelStr, "Only show logs at least as severe as this level, one of trace, debug, info, warn, error or fatal. Logs whose level cannot be detected are dropped too, like the lines of stack traces after the first one unless --group is given.")
+	flags.StringArrayVar(&o.highlightStrs, "highlight", nil, "Highlight the terms of the query DSL without filtering any logs. Can be repeated (e.g. --highlight 'req-42 or /trace_id=\\w+/')")
+	flags.IntVarP(&o.afterContext, "after-context", "A", 0, "Print NUM lines of trailing context from the same container after lines matching the query.")
+	flags.IntVarP(&o.beforeContext, "before-context", "B", 0, "Print NUM lines of leading context from the same container before lines matching the query.")
+	flags.IntVarP(&o.contextLines, "context-lines", "C", 0, "Print NUM lines of context from the same container around lines matching the query. Same as -A NUM -B NUM, except that -A and -B take precedence. Not --context, which selects the kubeconfig context.")
 
 	log.AddFlags(flags)
 
-	_ = cmd.Execute()
+	if err := cmd.Execute(); err != nil {
+		exit(err)
+	}
 }
@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
 	"regexp"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -11,7 +20,10 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 
+	"github.com/knight42/kt/pkg/api"
 	"github.com/knight42/kt/pkg/controller"
+	"github.com/knight42/kt/pkg/level"
+	"github.com/knight42/kt/pkg/log"
 	"github.com/knight42/kt/pkg/query"
 )
 
@@ -20,16 +32,48 @@ type Options struct {
 	selector     string
 	sinceSeconds time.Duration
 	sinceTime    string
-	timestamps bool
-	prefix string
+	timestamps   bool
+	tsFormat     string
+	tsInPrefix   bool
+	prefix       string
+	prefixFormat string
+	prefixLabels []string
 	tail         int64
-	container string
-	nodeName  string
-	queryStr  string
+	container    string
+	nodeName     string
+	queryStr     string
+	queryFile    string
+	explainQuery bool
+	failOnMatch  int
+	timeout      time.Duration
+	untilStr     string
+	untilPods    int
+	fieldNames   []string
+	output       string
+	jsonPretty   string
+	group        bool
+	groupStart   string
+
+	beforeContext int
+	afterContext  int
+	contextLines  int
+	// beforeContextSet and afterContextSet are set if -B and -A are given.
+	beforeContextSet bool
+	afterContextSet  bool
+	excludeStrs      []string
+	highlightStrs    []string
+	levelStr         string
 
 	restClientGetter genericclioptions.RESTClientGetter
 
-	queryExpr query.Expr
+	queryExpr      query.Expr
+	excludeExprs   []query.Expr
+	highlightTerms []query.Term
+	minLevel       level.Level
+	untilExpr      query.Expr
+	groupStartRe   *regexp.Regexp
+	template       *template.Template
+	prefixTemplate *template.Template
 
 	namespace string
 
@@ -50,7 +94,103 @@ func normalizeColor(color string) (string, error) {
 	}
 }
 
+// queryError renders an error of parsing the query given by the flag what,
+// pointing out where the error is if possible.
+func queryError(what string, err error) error {
+	var pe *query.ParseError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("invalid %s: %s\n%s", what, pe.Msg, pe.Snippet())
+	}
+	return fmt.Errorf("invalid %s: %w", what, err)
+}
+
+// completeQuery parses the query given by either --query or --query-file.
+func (o *Options) completeQuery() error {
+	if len(o.queryStr) > 0 && len(o.queryFile) > 0 {
+		return fmt.Errorf("only one of query / query-file may be used")
+	}
+	var err error
+	if len(o.queryStr) > 0 {
+		o.queryExpr, err = query.Parse(o.queryStr)
+		if err != nil {
+			return queryError("query", err)
+		}
+	}
+	if len(o.queryFile) > 0 {
+		content, err := os.ReadFile(o.queryFile)
+		if err != nil {
+			return err
+		}
+		o.queryExpr, err = query.ParseFile(string(content))
+		if err != nil {
+			return queryError("query file "+o.queryFile, err)
+		}
+	}
+	return nil
+}
+
+// Explain prints the query in a normalized form and its terms. If in is not
+// nil, it then prints for each line read from in which subexpressions of the
+// query match it.
+// completeContext resolves the lines of context around matches. -A and -B
+// given explicitly take precedence over -C, even if they are 0.
+func (o *Options) completeContext() error {
+	if o.beforeContext < 0 || o.afterContext < 0 || o.contextLines < 0 {
+		return fmt.Errorf("context lines must not be negative")
+	}
+	if !o.beforeContextSet {
+		o.beforeContext = o.contextLines
+	}
+	if !o.afterContextSet {
+		o.afterContext = o.contextLines
+	}
+	if o.beforeContext > 0 || o.afterContext > 0 {
+		if o.queryExpr == nil {
+			return fmt.Errorf("context lines can only be used with a query")
+		}
+		if _, ok := o.queryExpr.(*query.Sequence); ok {
+			return fmt.Errorf("context lines cannot be used with a sequence, which prints the lines in between already")
+		}
+	}
+	return nil
+}
+
+func (o *Options) Explain(in io.Reader, out io.Writer) error {
+	if o.queryExpr == nil {
+		return fmt.Errorf("explain-query requires a query")
+	}
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	_, _ = fmt.Fprintf(w, "query: %s\n", query.Format(o.queryExpr))
+	terms := o.queryExpr.Terms()
+	names := make([]string, 0, len(terms))
+	for _, t := range terms {
+		names = append(names, t.String())
+	}
+	_, _ = fmt.Fprintf(w, "terms: %s\n", strings.Join(names, ", "))
+	if in == nil {
+		return nil
+	}
+	sc := bufio.NewScanner(in)
+	for n := 1; sc.Scan(); n++ {
+		_, _ = fmt.Fprintf(w, "\nline %d: %s\n", n, sc.Text())
+		if err := query.Explain(w, o.queryExpr, &api.Log{Content: sc.Bytes()}); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
 func (o *Options) Complete(getter genericclioptions.RESTClientGetter, args []string) error {
+	if len(args) > 2 {
+		err := fmt.Errorf("too many arguments %q, want NAME_REGEXP or TYPE NAME", args)
+		// --fail-on-match N takes N as an argument, since the count is
+		// optional.
+		if o.failOnMatch == 0 {
+			err = fmt.Errorf("%w, pass a count as --fail-on-match=N", err)
+		}
+		return err
+	}
 	o.restClientGetter = getter
 
 	var err error
@@ -76,12 +216,132 @@ func (o *Options) Complete(getter genericclioptions.RESTClientGetter, args []str
 	default:
 		return fmt.Errorf("unknown value of flag `prefix`: %s", o.prefix)
 	}
+	if len(o.prefixFormat) > 0 {
+		if len(o.prefixLabels) > 0 {
+			return fmt.Errorf("prefix-format and prefix-labels cannot be used together")
+		}
+		o.prefixTemplate, err = controller.ParseTemplate(o.prefixFormat)
+		if err != nil {
+			return fmt.Errorf("invalid prefix format: %w", err)
+		}
+	}
+	for idx, name := range o.prefixLabels {
+		o.prefixLabels[idx] = strings.TrimSpace(name)
+		if len(o.prefixLabels[idx]) == 0 {
+			return fmt.Errorf("invalid prefix labels %q: empty label name", strings.Join(o.prefixLabels, ","))
+		}
+	}
 
-	if len(o.queryStr) > 0 {
-		o.queryExpr, err = query.Parse(o.queryStr)
+	if err := o.completeQuery(); err != nil {
+		return err
+	}
+
+	for _, s := range o.excludeStrs {
+		expr, err := query.Parse(s)
+		if err != nil {
+			return queryError("exclude", err)
+		}
+		if _, ok := expr.(*query.Sequence); ok {
+			return fmt.Errorf("invalid exclude: a sequence cannot be used to exclude lines")
+		}
+		o.excludeExprs = append(o.excludeExprs, expr)
+	}
+
+	for _, s := range o.highlightStrs {
+		expr, err := query.Parse(s)
+		if err != nil {
+			return queryError("highlight", err)
+		}
+		o.highlightTerms = append(o.highlightTerms, expr.Terms()...)
+	}
+
+	if o.failOnMatch < -1 {
+		return fmt.Errorf("fail-on-match must not be negative")
+	}
+	if o.failOnMatch >= 0 && o.queryExpr == nil {
+		return fmt.Errorf("fail-on-match can only be used with a query")
+	}
+	if o.timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+
+	if len(o.untilStr) > 0 {
+		o.untilExpr, err = query.Parse(o.untilStr)
+		if err != nil {
+			return queryError("until", err)
+		}
+		if o.untilPods < 1 {
+			return fmt.Errorf("until-pods must be at least 1")
+		}
+	}
+
+	for idx, name := range o.fieldNames {
+		o.fieldNames[idx] = strings.TrimSpace(name)
+		if len(o.fieldNames[idx]) == 0 {
+			return fmt.Errorf("invalid fields %q: empty field name", strings.Join(o.fieldNames, ","))
+		}
+	}
+
+	switch {
+	case o.output == "" || o.output == controller.OutputText:
+	case o.output == controller.OutputJSON:
+		if len(o.fieldNames) > 0 {
+			return fmt.Errorf("fields can only be used with the text output")
+		}
+	case strings.HasPrefix(o.output, controller.OutputTemplate+"="):
+		o.template, err = controller.ParseTemplate(strings.TrimPrefix(o.output, controller.OutputTemplate+"="))
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown output format: %s", o.output)
+	}
+
+	switch o.tsFormat {
+	case "", controller.TimestampLocal, controller.TimestampRelative, controller.TimestampDelta:
+	default:
+		// Any text is a valid layout, but one without any element of a
+		// layout is most likely a typo.
+		if time.Unix(0, 0).Format(o.tsFormat) == o.tsFormat {
+			return fmt.Errorf("invalid timestamp format %q: must be local, relative, delta or a Go time layout", o.tsFormat)
+		}
+	}
+	if len(o.tsFormat) > 0 || o.tsInPrefix {
+		o.timestamps = true
+	}
+
+	if len(o.groupStart) > 0 {
+		o.groupStartRe, err = regexp.Compile(o.groupStart)
+		if err != nil {
+			return fmt.Errorf("invalid group-start: %w", err)
+		}
+		o.group = true
+	}
+
+	switch o.jsonPretty {
+	case "", controller.JSONPrettyCompact, controller.JSONPrettyIndent:
+	default:
+		return fmt.Errorf("unknown value of flag `json-pretty`: %s", o.jsonPretty)
+	}
+	if len(o.jsonPretty) > 0 {
+		if o.output == controller.OutputJSON {
+			return fmt.Errorf("json-pretty cannot be used with the json output")
+		}
+		if len(o.fieldNames) > 0 {
+			return fmt.Errorf("json-pretty and fields cannot be used together")
+		}
+	}
+
+	if len(o.levelStr) > 0 {
+		l, err := level.Parse(o.levelStr)
 		if err != nil {
-			return fmt.Errorf("invalid query: %w", err)
+			return err
 		}
+		o.minLevel = l
+	}
+
+	if err := o.completeContext(); err != nil {
+		return err
 	}
 
 	switch len(args) {
@@ -136,7 +396,7 @@ func (o *Options) Run(cmd *cobra.Command) error {
 	if err != nil {
 		return err
 	}
-	c := controller.New(o.restClientGetter, &logsOptions,
+	opts := []controller.Option{
 		controller.WithColor(o.color),
 		controller.WithPodLabelsSelector(o.selector),
 		controller.WithPodNameRegexp(o.podNamePattern),
@@ -144,8 +404,53 @@ func (o *Options) Run(cmd *cobra.Command) error {
 		controller.WithPrefixMode(o.prefix),
 		controller.WithNodeName(o.nodeName),
 		controller.WithQuery(o.queryExpr),
-	)
-	return c.Run()
+		controller.WithContext(o.beforeContext, o.afterContext),
+		controller.WithExcludes(o.excludeExprs),
+		controller.WithHighlights(o.highlightTerms),
+		controller.WithMinLevel(o.minLevel),
+		controller.WithFields(o.fieldNames),
+		controller.WithJSONPretty(o.jsonPretty),
+		controller.WithTimestampFormat(o.tsFormat, o.tsInPrefix),
+	}
+	if o.group {
+		opts = append(opts, controller.WithGrouping(o.groupStartRe))
+	}
+	if o.prefixTemplate != nil {
+		opts = append(opts, controller.WithPrefixFormat(o.prefixTemplate))
+	}
+	if len(o.prefixLabels) > 0 {
+		opts = append(opts, controller.WithPrefixLabels(o.prefixLabels))
+	}
+	if o.template != nil {
+		opts = append(opts, controller.WithTemplate(o.template))
+	} else {
+		opts = append(opts, controller.WithOutput(o.output))
+	}
+	if o.failOnMatch >= 0 {
+		opts = append(opts, controller.WithFailOnMatch(o.failOnMatch))
+	}
+	if o.untilExpr != nil {
+		opts = append(opts, controller.WithUntil(o.untilExpr, o.untilPods))
+	}
+	c := controller.New(o.restClientGetter, &logsOptions, opts...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	err = c.Run(ctx)
+	for idx, n := range c.ExcludedCounts() {
+		log.Errorf("excluded %d lines by %q", n, o.excludeStrs[idx])
+	}
+	if err == nil && o.untilExpr != nil && !c.UntilReached() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s waiting for until", o.timeout)
+		}
+		return fmt.Errorf("stopped before until matched")
+	}
+	return err
 }
 
 func (o *Options) toLogsOptions() (corev1.PodLogOptions, error) {
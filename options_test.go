@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/knight42/kt/pkg/query"
+)
 
 func TestNormalizeColor(t *testing.T) {
 	cases := map[string]struct {
@@ -35,3 +41,122 @@ func TestNormalizeColor(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryError(t *testing.T) {
+	_, err := query.Parse("error and (timeout or")
+	got := queryError("query", err).Error()
+	want := "invalid query: unexpected end of query\n" +
+		"  error and (timeout or\n" +
+		"                       ^\n" +
+		`hint: "or" must be followed by a term`
+	if got != want {
+		t.Errorf("queryError() =\n%s\nwant\n%s", got, want)
+	}
+
+	other := errors.New("boom")
+	if got := queryError("exclude", other); !errors.Is(got, other) {
+		t.Errorf("queryError() = %v, want it to wrap %v", got, other)
+	}
+}
+
+func TestOptions_Explain(t *testing.T) {
+	o := Options{queryStr: `level=error or timeout and not /retry \d+/`}
+	if err := o.completeQuery(); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := o.Explain(strings.NewReader("timeout\n{\"level\":\"error\"}\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := `query: (level=error or (timeout and not /retry \d+/))
+terms: timeout
+
+line 1: timeout
++ (level=error or (timeout and not /retry \d+/))
+  - level=error
+  + (timeout and not /retry \d+/)
+    + timeout
+    + not /retry \d+/
+      - /retry \d+/
+
+line 2: {"level":"error"}
++ (level=error or (timeout and not /retry \d+/))
+  + level=error
+  - (timeout and not /retry \d+/)
+    - timeout
+    + not /retry \d+/
+      - /retry \d+/
+`
+	if out.String() != want {
+		t.Errorf("Explain() =\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	o = Options{}
+	if err := o.Explain(nil, &out); err == nil {
+		t.Error("Explain() without a query expected error, got nil")
+	}
+}
+
+func TestOptions_CompleteContext(t *testing.T) {
+	expr, err := query.Parse("panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		o                     Options
+		wantBefore, wantAfter int
+	}{
+		"context lines": {
+			o:          Options{contextLines: 3},
+			wantBefore: 3, wantAfter: 3,
+		},
+		"after takes precedence": {
+			o:          Options{contextLines: 3, afterContext: 1, afterContextSet: true},
+			wantBefore: 3, wantAfter: 1,
+		},
+		"explicit zero takes precedence": {
+			o:          Options{contextLines: 3, afterContextSet: true},
+			wantBefore: 3, wantAfter: 0,
+		},
+		"before and after": {
+			o:          Options{beforeContext: 2, beforeContextSet: true, afterContext: 1, afterContextSet: true},
+			wantBefore: 2, wantAfter: 1,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := tt.o
+			o.queryExpr = expr
+			if err := o.completeContext(); err != nil {
+				t.Fatalf("completeContext() = %v", err)
+			}
+			if o.beforeContext != tt.wantBefore || o.afterContext != tt.wantAfter {
+				t.Errorf("context = -B %d -A %d, want -B %d -A %d", o.beforeContext, o.afterContext, tt.wantBefore, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestOptions_CompleteTooManyArgs(t *testing.T) {
+	tests := map[string]struct {
+		o       Options
+		wantErr string
+	}{
+		"args": {
+			o:       Options{failOnMatch: -1},
+			wantErr: `too many arguments ["deploy" "foo" "bar"], want NAME_REGEXP or TYPE NAME`,
+		},
+		"fail-on-match count": {
+			o:       Options{failOnMatch: 0},
+			wantErr: `too many arguments ["deploy" "foo" "bar"], want NAME_REGEXP or TYPE NAME, pass a count as --fail-on-match=N`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.o.Complete(nil, []string{"deploy", "foo", "bar"})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Complete() = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
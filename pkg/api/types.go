@@ -1,14 +1,60 @@
 package api
 
 import (
+	"time"
+
 	"github.com/fatih/color"
+
+	"github.com/knight42/kt/pkg/fields"
+	"github.com/knight42/kt/pkg/level"
 )
 
 type Log struct {
+	Namespace string
 	Pod       string
 	Container string
-	Content   []byte
+	Node      string
+	// Labels are the labels of the pod, which must not be modified.
+	Labels map[string]string
+	// Revision is the revision of the Deployment of the pod, or empty if it
+	// is unknown or was not looked up.
+	Revision string
+	Content  []byte
+	// Timestamp is the time recorded by the API server for the line, or
+	// zero if it is unknown.
+	Timestamp time.Time
 
 	PodColor       *color.Color
 	ContainerColor *color.Color
+
+	fields       fields.Fields
+	fieldsParsed bool
+	level        level.Level
+	levelParsed  bool
+}
+
+// Fields returns Content decoded as a structured log, see fields.Parse.
+// The result is cached, so Content must not be changed after calling it.
+func (l *Log) Fields() (fields.Fields, bool) {
+	if !l.fieldsParsed {
+		l.fields, _ = fields.Parse(l.Content)
+		l.fieldsParsed = true
+	}
+	return l.fields, l.fields != nil
+}
+
+// Level returns the severity of the log, see level.Detect. The result is
+// cached like Fields.
+func (l *Log) Level() level.Level {
+	if !l.levelParsed {
+		fs, _ := l.Fields()
+		l.level = level.Detect(l.Content, fs)
+		l.levelParsed = true
+	}
+	return l.level
+}
+
+// Stream identifies the container which the log comes from.
+func (l *Log) Stream() string {
+	return l.Namespace + "/" + l.Pod + "/" + l.Container
 }
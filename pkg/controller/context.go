@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// contextIdle is how long the context of a container is kept after its last
+// line. Containers of deleted pods never log again, so their context has to
+// be dropped for it not to pile up while tailing for long.
+const contextIdle = 10 * time.Minute
+
+// contextFilter selects the lines around query matches, like grep -B/-A.
+// Lines are tracked per container so that the context of a match never
+// contains lines of other containers.
+type contextFilter struct {
+	before, after int
+	streams       map[string]*streamContext
+	// lastSweep is when idle streams were last dropped.
+	lastSweep time.Time
+}
+
+type streamContext struct {
+	// seq is the sequence number of the latest line in the stream.
+	seq uint64
+	// printed is the sequence number of the last selected line, 0 if none.
+	printed uint64
+	// afterLeft is the number of lines still to be selected after a match.
+	afterLeft int
+	// buffered holds up to `before` lines preceding the latest line.
+	buffered ring
+	// updated is when the latest line was received.
+	updated time.Time
+}
+
+type seqLog struct {
+	seq uint64
+	log *api.Log
+}
+
+func newContextFilter(before, after int) *contextFilter {
+	return &contextFilter{
+		before:  before,
+		after:   after,
+		streams: make(map[string]*streamContext),
+	}
+}
+
+// filter returns the lines to print on receiving l, which ends with l itself
+// if it matched the query. separated is true if the lines are not adjacent
+// to the ones previously printed from the same container.
+func (f *contextFilter) filter(l *api.Log, matched bool) (lines []*api.Log, separated bool) {
+	now := time.Now()
+	if now.Sub(f.lastSweep) > contextIdle {
+		f.sweep(now)
+	}
+	key := l.Stream()
+	st, ok := f.streams[key]
+	if !ok {
+		st = &streamContext{buffered: newRing(f.before)}
+		f.streams[key] = st
+	}
+	st.seq++
+	st.updated = now
+
+	switch {
+	case matched:
+		buffered := st.buffered.drain()
+		first := st.seq
+		if len(buffered) > 0 {
+			first = buffered[0].seq
+		}
+		for _, e := range buffered {
+			lines = append(lines, e.log)
+		}
+		lines = append(lines, l)
+		separated = st.printed > 0 && first > st.printed+1
+		st.printed = st.seq
+		st.afterLeft = f.after
+	case st.afterLeft > 0:
+		lines = append(lines, l)
+		st.printed = st.seq
+		st.afterLeft--
+	default:
+		st.buffered.push(seqLog{seq: st.seq, log: l})
+	}
+	return lines, separated
+}
+
+// sweep drops the context of the containers which have not logged anything
+// for contextIdle.
+func (f *contextFilter) sweep(now time.Time) {
+	f.lastSweep = now
+	for key, st := range f.streams {
+		if now.Sub(st.updated) > contextIdle {
+			delete(f.streams, key)
+		}
+	}
+}
+
+// ring is a fixed-size FIFO which drops the oldest entry when it is full.
+type ring struct {
+	entries []seqLog
+	start   int
+	size    int
+}
+
+func newRing(capacity int) ring {
+	return ring{entries: make([]seqLog, capacity)}
+}
+
+func (r *ring) push(e seqLog) {
+	if len(r.entries) == 0 {
+		return
+	}
+	if r.size < len(r.entries) {
+		r.entries[(r.start+r.size)%len(r.entries)] = e
+		r.size++
+		return
+	}
+	r.entries[r.start] = e
+	r.start = (r.start + 1) % len(r.entries)
+}
+
+// drain returns all entries from the oldest to the newest and empties r.
+func (r *ring) drain() []seqLog {
+	ret := make([]seqLog, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		e := &r.entries[(r.start+i)%len(r.entries)]
+		ret = append(ret, *e)
+		*e = seqLog{}
+	}
+	r.start, r.size = 0, 0
+	return ret
+}
@@ -2,10 +2,13 @@ package controller
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/fatih/color"
 	corev1 "k8s.io/api/core/v1"
@@ -17,19 +20,25 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 
 	"github.com/knight42/kt/pkg/api"
+	"github.com/knight42/kt/pkg/group"
+	"github.com/knight42/kt/pkg/level"
 	"github.com/knight42/kt/pkg/log"
 	"github.com/knight42/kt/pkg/query"
 	"github.com/knight42/kt/pkg/tailer"
 )
 
+// groupIdle is how long the record of a container is held when grouping
+// multi-line logs, before it is taken as complete.
+const groupIdle = 300 * time.Millisecond
+
 type Controller struct {
-	f            genericclioptions.RESTClientGetter
-	kubeClient   kubernetes.Interface
-	namespace    string
-	color        string
-	nodeName     string
-	prefixMode string
-	logsOptions  *corev1.PodLogOptions
+	f           genericclioptions.RESTClientGetter
+	kubeClient  kubernetes.Interface
+	namespace   string
+	color       string
+	nodeName    string
+	prefixMode  string
+	logsOptions *corev1.PodLogOptions
 
 	enableColor        bool
 	singlePodContainer atomic.Bool
@@ -41,9 +50,59 @@ type Controller struct {
 	podNameRegex       *regexp.Regexp
 	containerNameRegex *regexp.Regexp
 
-	queryExpr  query.Expr
-	podsTailer   map[types.UID]tailer.Tailer
-	newTailerFn  func(ns, name string, ctNames map[string]struct{}, enableColor bool, client kubernetes.Interface, logsOptions *corev1.PodLogOptions, logCh chan<- *api.Log) tailer.Tailer
+	queryExpr    query.Expr
+	excludeExprs []query.Expr
+	// excludedCounts is only accessed by consumeLog until Run returns.
+	excludedCounts []int
+	beforeContext  int
+	afterContext   int
+	// highlightTerms are highlighted without filtering anything.
+	highlightTerms []query.Term
+	// minLevel drops logs less severe than it, including those whose level
+	// is unknown, unless it is level.Unknown.
+	minLevel level.Level
+	// failOnMatch stops Run once the query matched more than matchLimit
+	// lines.
+	failOnMatch bool
+	matchLimit  int
+	// untilExpr stops Run once untilPods pods have each logged a line
+	// matching it after startTime. untilMatchedPods and untilReached are only accessed by
+	// consumeLog until Run returns.
+	untilExpr        query.Expr
+	untilPods        int
+	untilMatchedPods map[string]struct{}
+	untilReached     bool
+	// fieldNames projects structured logs to the values of these fields.
+	fieldNames []string
+	output     string
+	template   *template.Template
+	// prefixTemplate and prefixLabels customize the prefix, see
+	// WithPrefixFormat and WithPrefixLabels.
+	prefixTemplate *template.Template
+	prefixLabels   []string
+	// jsonPretty is how JSON logs are rendered, see WithJSONPretty.
+	jsonPretty string
+	// timestampFormat and timestampInPrefix are how timestamps are
+	// printed, see WithTimestampFormat. startTime and lastTimestamp are
+	// what relative and delta timestamps are relative to.
+	timestampFormat   string
+	timestampInPrefix bool
+	// grouping is set if the lines of multi-line logs are joined into
+	// records with groupStart, see WithGrouping.
+	grouping      bool
+	groupStart    *regexp.Regexp
+	startTime     time.Time
+	lastTimestamp time.Time
+	// needRevision is set if the prefix shows the revision of the pods,
+	// which are cached by ReplicaSet in revisions.
+	needRevision bool
+	revisions    map[string]string
+	// termMatcher finds the terms of queryExpr which are reported in the
+	// JSON output.
+	termMatcher *query.TermMatcher
+
+	podsTailer  map[types.UID]tailer.Tailer
+	newTailerFn func(pod *corev1.Pod, revision string, ctNames map[string]struct{}, enableColor bool, client kubernetes.Interface, logsOptions *corev1.PodLogOptions, logCh chan<- *api.Log) tailer.Tailer
 }
 
 func New(f genericclioptions.RESTClientGetter, logsOpts *corev1.PodLogOptions, opts ...Option) *Controller {
@@ -60,10 +119,12 @@ func New(f genericclioptions.RESTClientGetter, logsOpts *corev1.PodLogOptions, o
 	return c
 }
 
-func (c *Controller) Run() error {
-	go c.consumeLog()
-	defer close(c.logCh)
-
+// Run tails the logs until ctx is done or the pods can no longer be watched.
+// It returns a *MatchLimitError early if the query matched more lines than
+// allowed by WithFailOnMatch, and returns nil early once the condition given
+// by WithUntil is met.
+func (c *Controller) Run(ctx context.Context) error {
+	c.startTime = time.Now()
 	switch c.color {
 	case "always":
 		c.enableColor = true
@@ -115,9 +176,44 @@ func (c *Controller) Run() error {
 	if err != nil {
 		return err
 	}
-
 	defer watcher.Stop()
-	for ev := range watcher.ResultChan() {
+
+	quit, done := make(chan struct{}), make(chan struct{})
+	stop := make(chan error, 1)
+	go func() {
+		defer close(done)
+		c.consumeLog(quit, stop)
+	}()
+	// shutdown stops tailing and waits for the logs which are still
+	// pending to be handled, which may stop Run with an error too.
+	shutdown := func(err error) error {
+		for _, t := range c.podsTailer {
+			t.Close()
+		}
+		close(quit)
+		<-done
+		if err == nil {
+			select {
+			case err = <-stop:
+			default:
+			}
+		}
+		return err
+	}
+
+	for {
+		var ev watch.Event
+		select {
+		case <-ctx.Done():
+			return shutdown(nil)
+		case err := <-stop:
+			return shutdown(err)
+		case e, ok := <-watcher.ResultChan():
+			if !ok {
+				return shutdown(nil)
+			}
+			ev = e
+		}
 		pod, ok := ev.Object.(*corev1.Pod)
 		if !ok {
 			continue
@@ -137,7 +233,6 @@ func (c *Controller) Run() error {
 			c.onPodDeleted(pod)
 		}
 	}
-	return nil
 }
 
 func (c *Controller) onPodAdded(pod *corev1.Pod) {
@@ -147,8 +242,13 @@ func (c *Controller) onPodAdded(pod *corev1.Pod) {
 		log.V(4).Infof(">>>>> [DEBUG] no container found for pod: %s regex: %s", pod.Name, c.containerNameRegex)
 		return
 	}
+	var revision string
+	if c.needRevision {
+		revision = c.podRevision(pod)
+	}
 	t := c.newTailerFn(
-		c.namespace, pod.Name,
+		pod,
+		revision,
 		names,
 		c.enableColor,
 		c.kubeClient,
@@ -165,6 +265,7 @@ func (c *Controller) onPodModified(pod *corev1.Pod) {
 	if !ok {
 		return
 	}
+	t.SetPod(pod)
 	t.RetryContainers(getRetryableContainerNames(pod))
 }
 
@@ -202,29 +303,254 @@ func (c *Controller) shouldShowPrefix() bool {
 	}
 }
 
-func (c *Controller) consumeLog() {
-	var queryTerms [][]byte
-	if c.queryExpr != nil {
-		queryTerms = c.queryExpr.Terms()
+// ExcludedCounts returns the number of lines dropped by each exclude filter,
+// in the order they were given. It must be called after Run returns.
+func (c *Controller) ExcludedCounts() []int {
+	return c.excludedCounts
+}
+
+// excluded reports whether i is dropped by any of the exclude filters.
+func (c *Controller) excluded(i *api.Log) bool {
+	for idx, expr := range c.excludeExprs {
+		if expr.Match(i) {
+			c.excludedCounts[idx]++
+			return true
+		}
+	}
+	return false
+}
+
+// UntilReached reports whether Run returned because enough pods matched the
+// query given by WithUntil. It must be called after Run returns.
+func (c *Controller) UntilReached() bool {
+	return c.untilReached
+}
+
+// untilMatched reports whether enough pods have matched the query given by
+// WithUntil, now that i has been logged. Lines logged before Run started, like
+// the tail of the logs of pods which were already running, are not counted.
+func (c *Controller) untilMatched(i *api.Log) bool {
+	if !i.Timestamp.IsZero() && i.Timestamp.Before(c.startTime) {
+		return false
+	}
+	if !c.untilExpr.Match(i) {
+		return false
+	}
+	if c.untilMatchedPods == nil {
+		c.untilMatchedPods = make(map[string]struct{})
+	}
+	c.untilMatchedPods[i.Namespace+"/"+i.Pod] = struct{}{}
+	c.untilReached = len(c.untilMatchedPods) >= c.untilPods
+	return c.untilReached
+}
+
+// consumeLog prints the logs until quit is closed. It sends to stop, which
+// must be buffered, if Run should return early: a *MatchLimitError, or nil
+// once the condition of WithUntil is met. It sends at most once, including
+// while handling the records which are pending when quit is closed.
+func (c *Controller) consumeLog(quit <-chan struct{}, stop chan<- error) {
+	// matchHL highlights lines matching the query, contextHL the others.
+	var matchHL, contextHL *query.Highlighter
+	if c.enableColor {
+		var queryTerms []query.Term
+		if c.queryExpr != nil {
+			queryTerms = c.queryExpr.Terms()
+		}
+		// Terms to highlight come first so that they get the same colors
+		// in both highlighters.
+		terms := append(c.highlightTerms[:len(c.highlightTerms):len(c.highlightTerms)], queryTerms...)
+		if len(terms) > 0 {
+			matchHL = query.NewHighlighter(terms)
+		}
+		if len(c.highlightTerms) > 0 {
+			contextHL = query.NewHighlighter(c.highlightTerms)
+		}
 	}
+	var ctxFilter *contextFilter
+	if c.queryExpr != nil && (c.beforeContext > 0 || c.afterContext > 0) {
+		ctxFilter = newContextFilter(c.beforeContext, c.afterContext)
+	}
+	if c.queryExpr != nil && c.output == OutputJSON {
+		c.termMatcher = query.NewTermMatcher(c.queryExpr.Terms())
+	}
+	if c.enableColor {
+		for _, t := range []*template.Template{c.template, c.prefixTemplate} {
+			if t != nil {
+				withColors(t)
+			}
+		}
+	}
+	seq, _ := c.queryExpr.(*query.Sequence)
+	seqPrinted := false
+	var counter *matchCounter
+	if c.failOnMatch {
+		counter = newMatchCounter(c.matchLimit)
+	}
+	// Once stopped, logs are only drained so that the tailers are not
+	// blocked until Run returns.
+	stopped := false
+	countMatch := func(l *api.Log) {
+		if counter == nil {
+			return
+		}
+		if err := counter.add(l); err != nil {
+			stopped = true
+			stop <- err
+		}
+	}
+	c.excludedCounts = make([]int, len(c.excludeExprs))
 	w := bufio.NewWriter(os.Stdout)
-	for i := range c.logCh {
-		if c.queryExpr != nil && !c.queryExpr.Match(i.Content) {
-			continue
+	// handle prints i if it passes the filters and the query.
+	handle := func(i *api.Log) {
+		if c.excluded(i) {
+			return
+		}
+		if c.minLevel != level.Unknown && i.Level() < c.minLevel {
+			return
 		}
-		if c.shouldShowPrefix() {
-			if i.PodColor != nil {
-				_, _ = i.PodColor.Fprint(w, i.Pod)
-				_, _ = i.ContainerColor.Fprintf(w, "[%s] ", i.Container)
-			} else {
-				_, _ = w.WriteString(i.Pod + "[" + i.Container + "] ")
+		if seq != nil {
+			lines := seq.Feed(i)
+			if lines == nil {
+				return
 			}
+			// Each sequence is printed as a group like the context of a match.
+			if seqPrinted {
+				c.writeSeparator(w, i)
+			}
+			seqPrinted = true
+			for _, l := range lines {
+				c.writeLog(w, l, matchHL)
+			}
+			_ = w.Flush()
+			countMatch(i)
+			return
+		}
+		matched := c.queryExpr == nil || c.queryExpr.Match(i)
+		if ctxFilter == nil {
+			if !matched {
+				return
+			}
+			c.writeLog(w, i, matchHL)
+			_ = w.Flush()
+			countMatch(i)
+			return
 		}
-		content := i.Content
-		if len(queryTerms) > 0 && c.enableColor {
-			content = query.Highlight(content, queryTerms)
+
+		lines, separated := ctxFilter.filter(i, matched)
+		if separated {
+			c.writeSeparator(w, i)
+		}
+		for j, l := range lines {
+			// The query is only highlighted in the matched line, not its context.
+			lineHL := contextHL
+			if matched && j == len(lines)-1 {
+				lineHL = matchHL
+			}
+			c.writeLog(w, l, lineHL)
 		}
-		_, _ = w.Write(content)
 		_ = w.Flush()
+		if matched {
+			countMatch(i)
+		}
+	}
+	// handleAll handles logs until Run should stop.
+	handleAll := func(logs []*api.Log) {
+		for _, i := range logs {
+			if stopped {
+				return
+			}
+			handle(i)
+			if !stopped && c.untilExpr != nil && c.untilMatched(i) {
+				stopped = true
+				stop <- nil
+			}
+		}
+	}
+	var grouper *group.Grouper
+	var flushCh <-chan time.Time
+	if c.grouping {
+		grouper = group.New(c.groupStart)
+		ticker := time.NewTicker(groupIdle)
+		defer ticker.Stop()
+		flushCh = ticker.C
+	}
+	for {
+		select {
+		case <-quit:
+			// Handle the records which are still pending, as their
+			// containers will not log anything more.
+			if grouper != nil {
+				handleAll(grouper.Flush(time.Now().Add(time.Hour)))
+			}
+			return
+		case i := <-c.logCh:
+			if grouper == nil {
+				handleAll([]*api.Log{i})
+			} else if rec := grouper.Add(i, time.Now()); rec != nil {
+				handleAll([]*api.Log{rec})
+			}
+		case now := <-flushCh:
+			handleAll(grouper.Flush(now.Add(-groupIdle)))
+		}
+	}
+}
+
+func (c *Controller) writePrefix(w *bufio.Writer, i *api.Log) {
+	if !c.shouldShowPrefix() {
+		return
+	}
+	if c.prefixTemplate != nil {
+		c.writePrefixTemplate(w, i)
+		return
+	}
+	if len(c.prefixLabels) > 0 {
+		c.writePrefixWithLabels(w, i)
+		return
+	}
+	if i.PodColor != nil {
+		_, _ = i.PodColor.Fprint(w, i.Pod)
+		_, _ = i.ContainerColor.Fprintf(w, "[%s] ", i.Container)
+	} else {
+		_, _ = w.WriteString(i.Pod + "[" + i.Container + "] ")
+	}
+}
+
+// writeSeparator separates groups of lines which are not contiguous, e.g. the
+// context of two matches.
+func (c *Controller) writeSeparator(w *bufio.Writer, i *api.Log) {
+	switch c.output {
+	case OutputJSON:
+		return
+	case OutputTemplate:
+	default:
+		c.writePrefix(w, i)
+	}
+	_, _ = w.WriteString("--\n")
+}
+
+func (c *Controller) writeLog(w *bufio.Writer, i *api.Log, hl *query.Highlighter) {
+	if c.output == OutputJSON {
+		c.writeJSON(w, i)
+		return
+	}
+	content, ok := c.renderJSON(i, hl)
+	if !ok {
+		content = c.content(i)
+		if hl != nil {
+			content = hl.Highlight(content)
+		}
+	}
+	if c.output == OutputTemplate {
+		c.writeTemplate(w, i, content)
+		return
+	}
+	ts := c.formatTimestamp(i)
+	if len(ts) > 0 && c.timestampInPrefix {
+		c.writeTimestamp(w, i, ts)
+	}
+	c.writePrefix(w, i)
+	if len(ts) > 0 && !c.timestampInPrefix {
+		_, _ = w.WriteString(ts + " ")
 	}
+	_, _ = w.Write(content)
 }
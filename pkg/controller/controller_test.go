@@ -1,20 +1,30 @@
 package controller
 
 import (
+	"bufio"
+	"context"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/knight42/kt/pkg/api"
+	"github.com/knight42/kt/pkg/query"
 	"github.com/knight42/kt/pkg/tailer"
 )
 
 type fakeTailer struct {
 	containerCount int
 	onTail         func()
+	// pod is the latest pod given to SetPod.
+	pod *corev1.Pod
 }
 
 func (f *fakeTailer) Tail() {
@@ -23,8 +33,9 @@ func (f *fakeTailer) Tail() {
 	}
 }
 func (f *fakeTailer) RetryContainers(names []string) {}
-func (f *fakeTailer) ContainerCount() int          { return f.containerCount }
-func (f *fakeTailer) Close()                       {}
+func (f *fakeTailer) SetPod(pod *corev1.Pod)         { f.pod = pod }
+func (f *fakeTailer) ContainerCount() int            { return f.containerCount }
+func (f *fakeTailer) Close()                         {}
 
 var _ tailer.Tailer = (*fakeTailer)(nil)
 
@@ -97,7 +108,7 @@ func TestOnPodAdded_PrefixStateSetBeforeTail(t *testing.T) {
 		logCh:       make(chan *api.Log, 1),
 		logsOptions: &corev1.PodLogOptions{},
 	}
-	c.newTailerFn = func(ns, name string, ctNames map[string]struct{}, enableColor bool, client kubernetes.Interface, logsOptions *corev1.PodLogOptions, logCh chan<- *api.Log) tailer.Tailer {
+	c.newTailerFn = func(pod *corev1.Pod, revision string, ctNames map[string]struct{}, enableColor bool, client kubernetes.Interface, logsOptions *corev1.PodLogOptions, logCh chan<- *api.Log) tailer.Tailer {
 		ft := &fakeTailer{containerCount: len(ctNames)}
 		ft.onTail = func() {
 			tailCalled = true
@@ -128,6 +139,38 @@ func TestOnPodAdded_PrefixStateSetBeforeTail(t *testing.T) {
 	}
 }
 
+func TestOnPodModified_UpdatesPod(t *testing.T) {
+	c := &Controller{
+		prefixMode:  "auto",
+		podsTailer:  make(map[types.UID]tailer.Tailer),
+		logCh:       make(chan *api.Log, 1),
+		logsOptions: &corev1.PodLogOptions{},
+	}
+	ft := &fakeTailer{}
+	c.newTailerFn = func(pod *corev1.Pod, revision string, ctNames map[string]struct{}, enableColor bool, client kubernetes.Interface, logsOptions *corev1.PodLogOptions, logCh chan<- *api.Log) tailer.Tailer {
+		ft.containerCount = len(ctNames)
+		ft.pod = pod
+		return ft
+	}
+
+	// A new pod is not scheduled yet when it is added.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", UID: "uid-1"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	c.onPodAdded(pod)
+	if ft.pod.Spec.NodeName != "" {
+		t.Fatalf("node = %q before the pod is scheduled", ft.pod.Spec.NodeName)
+	}
+
+	scheduled := pod.DeepCopy()
+	scheduled.Spec.NodeName = "node-1"
+	c.onPodModified(scheduled)
+	if ft.pod.Spec.NodeName != "node-1" {
+		t.Errorf("node = %q after the pod is scheduled, want node-1", ft.pod.Spec.NodeName)
+	}
+}
+
 func TestUpdatePrefixState_SkipsNonAuto(t *testing.T) {
 	modes := map[string]struct{}{
 		"always": {},
@@ -149,3 +192,845 @@ func TestUpdatePrefixState_SkipsNonAuto(t *testing.T) {
 		})
 	}
 }
+
+func TestContextFilter(t *testing.T) {
+	type input struct {
+		container string
+		content   string
+		matched   bool
+	}
+	tests := map[string]struct {
+		before, after int
+		inputs        []input
+		// want is the printed output, with "--" for separators.
+		want []string
+	}{
+		"before context": {
+			before: 2,
+			inputs: []input{
+				{"app", "1", false},
+				{"app", "2", false},
+				{"app", "3", false},
+				{"app", "4", true},
+				{"app", "5", false},
+			},
+			want: []string{"2", "3", "4"},
+		},
+		"after context": {
+			after: 1,
+			inputs: []input{
+				{"app", "1", true},
+				{"app", "2", false},
+				{"app", "3", false},
+			},
+			want: []string{"1", "2"},
+		},
+		"separator between non-adjacent groups": {
+			before: 1,
+			after:  1,
+			inputs: []input{
+				{"app", "1", true},
+				{"app", "2", false},
+				{"app", "3", false},
+				{"app", "4", false},
+				{"app", "5", true},
+			},
+			want: []string{"1", "2", "--", "4", "5"},
+		},
+		"no separator between adjacent groups": {
+			before: 1,
+			after:  1,
+			inputs: []input{
+				{"app", "1", true},
+				{"app", "2", false},
+				{"app", "3", false},
+				{"app", "4", true},
+			},
+			want: []string{"1", "2", "3", "4"},
+		},
+		"overlapping context is not repeated": {
+			before: 2,
+			after:  2,
+			inputs: []input{
+				{"app", "1", true},
+				{"app", "2", true},
+				{"app", "3", false},
+				{"app", "4", false},
+				{"app", "5", false},
+			},
+			want: []string{"1", "2", "3", "4"},
+		},
+		"context never mixes containers": {
+			before: 1,
+			after:  1,
+			inputs: []input{
+				{"app", "app-1", false},
+				{"sidecar", "sidecar-1", false},
+				{"app", "app-2", true},
+				{"sidecar", "sidecar-2", false},
+				{"app", "app-3", false},
+				{"sidecar", "sidecar-3", true},
+			},
+			want: []string{"app-1", "app-2", "app-3", "sidecar-2", "sidecar-3"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newContextFilter(tt.before, tt.after)
+			var got []string
+			for _, in := range tt.inputs {
+				l := &api.Log{Pod: "pod", Container: in.container, Content: []byte(in.content)}
+				lines, separated := f.filter(l, in.matched)
+				if separated {
+					got = append(got, "--")
+				}
+				for _, l := range lines {
+					got = append(got, string(l.Content))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("filter() printed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextFilter_Sweep(t *testing.T) {
+	f := newContextFilter(2, 0)
+	app := &api.Log{Pod: "pod", Container: "app", Content: []byte("line")}
+	sidecar := &api.Log{Pod: "pod", Container: "sidecar", Content: []byte("line")}
+	f.filter(app, false)
+	f.filter(sidecar, false)
+	// Only the containers which stay idle are dropped.
+	now := time.Now()
+	f.streams[sidecar.Stream()].updated = now.Add(-contextIdle - time.Second)
+	f.sweep(now)
+	if _, ok := f.streams[sidecar.Stream()]; ok {
+		t.Error("the context of an idle container is kept")
+	}
+	if _, ok := f.streams[app.Stream()]; !ok {
+		t.Error("the context of an active container is dropped")
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	var exprs []query.Expr
+	for _, s := range []string{"healthz", "level=debug or metrics"} {
+		expr, err := query.Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", s, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	c := &Controller{excludeExprs: exprs, excludedCounts: make([]int, len(exprs))}
+	lines := map[string]bool{
+		"GET /healthz 200":            true,
+		"GET /metrics 200":            true,
+		"level=debug msg=tick":        true,
+		"GET /healthz level=debug":    true,
+		"level=error msg=\"timeout\"": false,
+	}
+	for line, want := range lines {
+		if got := c.excluded(&api.Log{Content: []byte(line)}); got != want {
+			t.Errorf("excluded(%q) = %v, want %v", line, got, want)
+		}
+	}
+	// A line is only counted by the first exclude which drops it.
+	if got, want := c.ExcludedCounts(), []int{2, 2}; got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("ExcludedCounts() = %v, want %v", got, want)
+	}
+}
+
+func TestWriteLog_Timestamps(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 10, 0, 0, 120000000, time.UTC)
+	tests := map[string]struct {
+		timestamps bool
+		log        *api.Log
+		want       string
+	}{
+		"not requested": {
+			log:  &api.Log{Pod: "web", Container: "app", Content: []byte("hello\n"), Timestamp: ts},
+			want: "web[app] hello\n",
+		},
+		"requested": {
+			timestamps: true,
+			log:        &api.Log{Pod: "web", Container: "app", Content: []byte("hello\n"), Timestamp: ts},
+			want:       "web[app] 2026-10-18T10:00:00.120000000Z hello\n",
+		},
+		"requested but unknown": {
+			timestamps: true,
+			log:        &api.Log{Pod: "web", Container: "app", Content: []byte("hello\n")},
+			want:       "web[app] hello\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{prefixMode: "always", logsOptions: &corev1.PodLogOptions{Timestamps: tt.timestamps}}
+			var sb strings.Builder
+			w := bufio.NewWriter(&sb)
+			c.writeLog(w, tt.log, nil)
+			_ = w.Flush()
+			if sb.String() != tt.want {
+				t.Errorf("writeLog() = %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchCounter(t *testing.T) {
+	m := newMatchCounter(2)
+	lines := []*api.Log{
+		{Pod: "web-1", Container: "app", Content: []byte("panic: a\n")},
+		{Pod: "web-2", Container: "app", Content: []byte("panic: b\n")},
+		{Pod: "web-1", Container: "sidecar", Content: []byte("panic: c")},
+	}
+	for _, l := range lines[:2] {
+		if err := m.add(l); err != nil {
+			t.Fatalf("add() = %v before the limit", err)
+		}
+	}
+	err := m.add(lines[2])
+	mle, ok := err.(*MatchLimitError)
+	if !ok {
+		t.Fatalf("add() = %v, want *MatchLimitError", err)
+	}
+	if mle.Error() != "query matched more than 2 lines" {
+		t.Errorf("Error() = %q", mle.Error())
+	}
+	var sb strings.Builder
+	mle.WriteReport(&sb)
+	want := `3 matched lines by pod:
+  web-1: 2
+  web-2: 1
+first 3 matched lines:
+  web-1[app] panic: a
+  web-2[app] panic: b
+  web-1[sidecar] panic: c
+`
+	if sb.String() != want {
+		t.Errorf("WriteReport() =\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestMatchCounter_ReportedMatchesAreBounded(t *testing.T) {
+	m := newMatchCounter(100)
+	for range 100 {
+		_ = m.add(&api.Log{Pod: "web", Content: []byte("panic")})
+	}
+	if len(m.matches) != maxReportedMatches {
+		t.Errorf("%d matches kept, want %d", len(m.matches), maxReportedMatches)
+	}
+}
+
+func TestConsumeLog_FailOnMatch(t *testing.T) {
+	expr, err := query.Parse("panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{
+		prefixMode:  "never",
+		logCh:       make(chan *api.Log),
+		queryExpr:   expr,
+		failOnMatch: true,
+	}
+	quit, stop := make(chan struct{}), make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.consumeLog(quit, stop)
+	}()
+	c.logCh <- &api.Log{Pod: "web", Content: []byte("ok\n")}
+	c.logCh <- &api.Log{Pod: "web", Content: []byte("panic\n")}
+	// Logs are still drained after stopping.
+	c.logCh <- &api.Log{Pod: "web", Content: []byte("panic\n")}
+	select {
+	case err := <-stop:
+		if mle, ok := err.(*MatchLimitError); !ok || mle.Count != 1 {
+			t.Errorf("stop = %v, want *MatchLimitError after 1 match", err)
+		}
+	default:
+		t.Error("consumeLog did not stop")
+	}
+	close(quit)
+	<-done
+}
+
+func TestConsumeLog_Until(t *testing.T) {
+	until, err := query.Parse(`"server started"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := query.Parse("started")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{
+		prefixMode:   "never",
+		logCh:        make(chan *api.Log),
+		excludeExprs: []query.Expr{exclude},
+		untilExpr:    until,
+		untilPods:    2,
+	}
+	quit, stop := make(chan struct{}), make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.consumeLog(quit, stop)
+	}()
+	send := func(pod, content string) {
+		c.logCh <- &api.Log{Namespace: "default", Pod: pod, Content: []byte(content)}
+	}
+	stopped := func() bool {
+		select {
+		case err := <-stop:
+			if err != nil {
+				t.Errorf("stop = %v, want nil", err)
+			}
+			return true
+		default:
+			return false
+		}
+	}
+
+	send("web-1", "server started\n")
+	send("web-1", "server started\n")
+	// Wait for the previous line to be handled.
+	send("web-1", "noise\n")
+	if stopped() {
+		t.Fatal("stopped after one pod matched")
+	}
+	// Lines are matched even if they are excluded from the output.
+	send("web-2", "server started\n")
+	send("web-2", "noise\n")
+	if !stopped() {
+		t.Fatal("not stopped after two pods matched")
+	}
+	close(quit)
+	<-done
+	if !c.UntilReached() {
+		t.Error("UntilReached() = false, want true")
+	}
+}
+
+func TestUntilMatched_History(t *testing.T) {
+	until, err := query.Parse("ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	c := &Controller{untilExpr: until, untilPods: 1, startTime: start}
+	// The tail of the logs of an old pod was logged before kt started.
+	old := &api.Log{Namespace: "default", Pod: "web-old", Content: []byte("ready\n"), Timestamp: start.Add(-time.Minute)}
+	if c.untilMatched(old) {
+		t.Fatal("a line logged before kt started matched --until")
+	}
+	if c.UntilReached() {
+		t.Fatal("UntilReached() = true after a line logged before kt started")
+	}
+	cur := &api.Log{Namespace: "default", Pod: "web-new", Content: []byte("ready\n"), Timestamp: start}
+	if !c.untilMatched(cur) {
+		t.Error("a line logged when kt started did not match --until")
+	}
+}
+
+func TestWriteLog_Fields(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    string
+	}{
+		"json":       {content: `{"msg":"request failed","level":"error","ts":"10:00","size":1024}` + "\n", want: "10:00 error request failed -\n"},
+		"logfmt":     {content: "ts=10:00 level=warn msg=\"slow query\" err=timeout\n", want: "10:00 warn slow query timeout\n"},
+		"plain text": {content: "panic: runtime error\n", want: "panic: runtime error\n"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{prefixMode: "never", fieldNames: []string{"ts", "level", "msg", "err"}}
+			var sb strings.Builder
+			w := bufio.NewWriter(&sb)
+			c.writeLog(w, &api.Log{Content: []byte(tt.content)}, nil)
+			_ = w.Flush()
+			if sb.String() != tt.want {
+				t.Errorf("writeLog() = %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLog_JSON(t *testing.T) {
+	expr, err := query.Parse(`timeout or /status=5\d\d/ or timeout or missing`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2026, 10, 18, 10, 0, 0, 120000000, time.UTC)
+	tests := map[string]struct {
+		log  *api.Log
+		want string
+	}{
+		"plain text": {
+			log:  &api.Log{Namespace: "prod", Pod: "web-1", Container: "app", Node: "node-1", Content: []byte("request timeout, status=503 <html>\n"), Timestamp: ts},
+			want: `{"namespace":"prod","pod":"web-1","container":"app","node":"node-1","timestamp":"2026-10-18T10:00:00.12Z","message":"request timeout, status=503 <html>","terms":["timeout","/status=5\\d\\d/"]}` + "\n",
+		},
+		"json object": {
+			log:  &api.Log{Namespace: "prod", Pod: "web-1", Container: "app", Content: []byte(`{"level":"error", "msg":"timeout"}` + "\n")},
+			want: `{"namespace":"prod","pod":"web-1","container":"app","node":"","message":{"level":"error","msg":"timeout"},"terms":["timeout"]}` + "\n",
+		},
+		"invalid json": {
+			log:  &api.Log{Pod: "web-1", Content: []byte(`{"level":` + "\n")},
+			want: `{"namespace":"","pod":"web-1","container":"","node":"","message":"{\"level\":","terms":[]}` + "\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{prefixMode: "always", output: OutputJSON, termMatcher: query.NewTermMatcher(expr.Terms())}
+			var sb strings.Builder
+			w := bufio.NewWriter(&sb)
+			c.writeLog(w, tt.log, nil)
+			c.writeSeparator(w, tt.log)
+			_ = w.Flush()
+			if sb.String() != tt.want {
+				t.Errorf("writeLog() =\n%s\nwant:\n%s", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestShortPodName(t *testing.T) {
+	tests := map[string]string{
+		"web-7d9f8c6b5-x2x4z":         "web-x2x4z",
+		"api-server-5c8d7f9b4d-q7z2k": "api-server-q7z2k",
+		"backup-28290000-q7z2k":       "backup-q7z2k",
+		"db-0":                        "db-0",
+		"node-agent-x2x4z":            "node-agent-x2x4z",
+		"standalone":                  "standalone",
+	}
+	for name, want := range tests {
+		if got := shortPodName(name); got != want {
+			t.Errorf("shortPodName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseTemplate_Errors(t *testing.T) {
+	tests := map[string]string{
+		"syntax":           "{{.Pod",
+		"unknown field":    "{{.Tim}}",
+		"unknown function": "{{.Pod | long}}",
+		"wrong arguments":  "{{colorByLevel .Message}}",
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseTemplate(text); err == nil {
+				t.Errorf("ParseTemplate(%q) expected error, got nil", text)
+			}
+		})
+	}
+}
+
+func TestWriteLog_Template(t *testing.T) {
+	expr, err := query.Parse("timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2026, 10, 18, 10, 0, 5, 0, time.UTC)
+	log := &api.Log{
+		Namespace: "prod",
+		Pod:       "web-7d9f8c6b5-x2x4z",
+		Container: "app",
+		Content:   []byte(`{"level":"error","msg":"timeout","user":{"id":42}}` + "\n"),
+		Timestamp: ts,
+	}
+	tests := map[string]struct {
+		text        string
+		enableColor bool
+		want        string
+	}{
+		"metadata": {
+			text: `{{.Time.Format "15:04:05"}} {{.Namespace}}/{{.Pod|short}}[{{.Container}}] {{.Level}}`,
+			want: "10:00:05 prod/web-x2x4z[app] error\n",
+		},
+		"fields": {
+			text: `{{field "msg" .}} user={{field "user.id" .}} missing={{field "trace" .}} {{index .Fields "level"}}`,
+			want: "timeout user=42 missing= error\n",
+		},
+		"color by level without colors": {
+			text: `{{colorByLevel .Level .Pod}}`,
+			want: "web-7d9f8c6b5-x2x4z\n",
+		},
+		"color by level": {
+			text:        `{{colorByLevel .Level (short .Pod)}} {{.Message}}`,
+			enableColor: true,
+			want:        "\033[31mweb-x2x4z\033[0m " + `{"level":"error","msg":"` + "\033[1;31mtimeout\033[0m" + `","user":{"id":42}}` + "\n",
+		},
+		"trailing newline is kept": {
+			text: "{{.Container}}\n",
+			want: "app\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpl, err := ParseTemplate(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.enableColor {
+				withColors(tmpl)
+			}
+			var hl *query.Highlighter
+			if tt.enableColor {
+				hl = query.NewHighlighter(expr.Terms())
+			}
+			c := &Controller{prefixMode: "always", output: OutputTemplate, template: tmpl}
+			var sb strings.Builder
+			w := bufio.NewWriter(&sb)
+			c.writeLog(w, log, hl)
+			_ = w.Flush()
+			if sb.String() != tt.want {
+				t.Errorf("writeLog() = %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLog_Prefix(t *testing.T) {
+	l := &api.Log{
+		Namespace: "prod",
+		Pod:       "web-7d9f8c6b5-x2x4z",
+		Container: "app",
+		Node:      "node-1",
+		Labels:    map[string]string{"app": "web", "version": "v2"},
+		Revision:  "3",
+		Content:   []byte("hello\n"),
+	}
+	tests := map[string]struct {
+		format string
+		labels []string
+		want   string
+	}{
+		"labels": {
+			labels: []string{"version", "app"},
+			want:   "web-7d9f8c6b5-x2x4z[app]{version=v2,app=web} hello\n",
+		},
+		"missing labels": {
+			labels: []string{"tier", "app"},
+			want:   "web-7d9f8c6b5-x2x4z[app]{app=web} hello\n",
+		},
+		"no labels": {
+			labels: []string{"tier"},
+			want:   "web-7d9f8c6b5-x2x4z[app] hello\n",
+		},
+		"format": {
+			format: "{{.Namespace}}/{{.Pod|short}}@{{.Node}} r{{.Revision}} {{.Labels.app}} ",
+			want:   "prod/web-x2x4z@node-1 r3 web hello\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{prefixMode: "always", prefixLabels: tt.labels, logsOptions: &corev1.PodLogOptions{}}
+			if len(tt.format) > 0 {
+				tmpl, err := ParseTemplate(tt.format)
+				if err != nil {
+					t.Fatalf("ParseTemplate() = %v", err)
+				}
+				c.prefixTemplate = tmpl
+			}
+			var sb strings.Builder
+			w := bufio.NewWriter(&sb)
+			c.writeLog(w, l, nil)
+			_ = w.Flush()
+			if sb.String() != tt.want {
+				t.Errorf("writeLog() = %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedRevision(t *testing.T) {
+	parse := func(text string) *template.Template {
+		tmpl, err := ParseTemplate(text)
+		if err != nil {
+			t.Fatalf("ParseTemplate(%q) = %v", text, err)
+		}
+		return tmpl
+	}
+	tests := map[string]struct {
+		opts []Option
+		want bool
+	}{
+		"output": {
+			opts: []Option{WithTemplate(parse("{{.Revision}} {{.Message}}"))},
+			want: true,
+		},
+		"prefix": {
+			opts: []Option{WithPrefixFormat(parse("r{{.Revision}} "))},
+			want: true,
+		},
+		"output before prefix": {
+			opts: []Option{WithTemplate(parse("{{.Revision}}")), WithPrefixFormat(parse("{{.Pod}} "))},
+			want: true,
+		},
+		"neither": {
+			opts: []Option{WithTemplate(parse("{{.Message}}")), WithPrefixFormat(parse("{{.Pod}} "))},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{}
+			for _, opt := range tt.opts {
+				opt(c)
+			}
+			if c.needRevision != tt.want {
+				t.Errorf("needRevision = %v, want %v", c.needRevision, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodRevision(t *testing.T) {
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "prod",
+		Name:        "web-7d9f8c6b5",
+		Annotations: map[string]string{revisionAnnotation: "3"},
+	}}
+	client := fake.NewSimpleClientset(rs)
+	c := &Controller{kubeClient: client}
+	isController := true
+	pod := func(kind, owner string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "prod",
+			Name:      owner + "-x2x4z",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: kind, Name: owner, Controller: &isController},
+			},
+		}}
+	}
+	if got := c.podRevision(pod("ReplicaSet", "web-7d9f8c6b5")); got != "3" {
+		t.Errorf("podRevision() = %q, want 3", got)
+	}
+	if got := c.podRevision(pod("StatefulSet", "db")); got != "" {
+		t.Errorf("podRevision() of a StatefulSet pod = %q, want none", got)
+	}
+	if got := c.podRevision(pod("ReplicaSet", "gone")); got != "" {
+		t.Errorf("podRevision() of a missing ReplicaSet = %q, want none", got)
+	}
+
+	// The revision is looked up once per ReplicaSet.
+	_ = client.AppsV1().ReplicaSets("prod").Delete(context.Background(), rs.Name, metav1.DeleteOptions{})
+	if got := c.podRevision(pod("ReplicaSet", "web-7d9f8c6b5")); got != "3" {
+		t.Errorf("podRevision() after the lookup = %q, want 3", got)
+	}
+}
+
+func TestWriteLog_JSONPretty(t *testing.T) {
+	const line = `{"ts":1.5,"msg":"user <a> logged in","level":"warn","user":{"id":7,"tags":["x"]},"ok":true,"note":"a b"}` + "\n"
+	tests := map[string]struct {
+		mode    string
+		color   bool
+		content string
+		terms   string
+		want    string
+	}{
+		"compact": {
+			mode:    JSONPrettyCompact,
+			content: line,
+			want:    `warn user <a> logged in ts=1.5 user={"id":7,"tags":["x"]} ok=true note="a b"` + "\n",
+		},
+		"indent": {
+			mode:    JSONPrettyIndent,
+			content: line,
+			want: `{
+  "ts": 1.5,
+  "msg": "user <a> logged in",
+  "level": "warn",
+  "user": {
+    "id": 7,
+    "tags": [
+      "x"
+    ]
+  },
+  "ok": true,
+  "note": "a b"
+}
+`,
+		},
+		"invalid": {
+			mode:    JSONPrettyCompact,
+			content: `{"msg":"cut` + "\n",
+			want:    `{"msg":"cut` + "\n",
+		},
+		"trailing data": {
+			mode:    JSONPrettyCompact,
+			content: `{"msg":"a"} {"msg":"b"}` + "\n",
+			want:    `{"msg":"a"} {"msg":"b"}` + "\n",
+		},
+		"not an object": {
+			mode:    JSONPrettyIndent,
+			content: `["a"]` + "\n",
+			want:    `["a"]` + "\n",
+		},
+		"highlighted": {
+			mode:    JSONPrettyCompact,
+			color:   true,
+			content: `{"level":"error","msg":"db down","host":"db-1"}` + "\n",
+			terms:   "db",
+			want: "\033[31merror\033[0m \033[1;31mdb\033[0m down " +
+				"\033[34mhost\033[0m=\033[32m\033[1;31mdb\033[0m\033[32m-1\033[0m\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{prefixMode: "never", jsonPretty: tt.mode, enableColor: tt.color}
+			var hl *query.Highlighter
+			if len(tt.terms) > 0 {
+				expr, err := query.Parse(tt.terms)
+				if err != nil {
+					t.Fatalf("Parse() = %v", err)
+				}
+				hl = query.NewHighlighter(expr.Terms())
+			}
+			var sb strings.Builder
+			w := bufio.NewWriter(&sb)
+			c.writeLog(w, &api.Log{Pod: "web", Container: "app", Content: []byte(tt.content)}, hl)
+			_ = w.Flush()
+			if sb.String() != tt.want {
+				t.Errorf("writeLog() = %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	start := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *api.Log {
+		return &api.Log{Pod: "web", Container: "app", Content: []byte("hello\n"), Timestamp: start.Add(d)}
+	}
+	tests := map[string]struct {
+		format string
+		logs   []*api.Log
+		want   []string
+	}{
+		"default": {
+			logs: []*api.Log{at(120 * time.Millisecond)},
+			want: []string{"2026-10-18T10:00:00.120000000Z"},
+		},
+		"layout": {
+			format: "15:04:05.000",
+			logs:   []*api.Log{at(120 * time.Millisecond)},
+			want:   []string{"10:00:00.120"},
+		},
+		"relative": {
+			format: TimestampRelative,
+			logs:   []*api.Log{at(1204 * time.Millisecond), at(-2500 * time.Millisecond)},
+			want:   []string{"+1.204s", "-2.500s"},
+		},
+		"delta": {
+			format: TimestampDelta,
+			logs:   []*api.Log{at(time.Second), at(1500 * time.Millisecond), at(61 * time.Second)},
+			want:   []string{"+0.000s", "+0.500s", "+59.500s"},
+		},
+		"no timestamp": {
+			format: TimestampDelta,
+			logs:   []*api.Log{{Pod: "web", Container: "app"}},
+			want:   []string{""},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{
+				logsOptions:     &corev1.PodLogOptions{Timestamps: true},
+				timestampFormat: tt.format,
+				startTime:       start,
+			}
+			for idx, l := range tt.logs {
+				if got := c.formatTimestamp(l); got != tt.want[idx] {
+					t.Errorf("formatTimestamp() of line %d = %q, want %q", idx, got, tt.want[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteLog_TimestampInPrefix(t *testing.T) {
+	c := &Controller{
+		prefixMode:        "always",
+		logsOptions:       &corev1.PodLogOptions{Timestamps: true},
+		timestampFormat:   "15:04:05",
+		timestampInPrefix: true,
+	}
+	var sb strings.Builder
+	w := bufio.NewWriter(&sb)
+	c.writeLog(w, &api.Log{Pod: "web", Container: "app", Content: []byte("hello\n"), Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)}, nil)
+	_ = w.Flush()
+	if want := "10:00:00 web[app] hello\n"; sb.String() != want {
+		t.Errorf("writeLog() = %q, want %q", sb.String(), want)
+	}
+}
+
+func TestConsumeLog_Grouping(t *testing.T) {
+	q, err := query.Parse(`"App.java"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		c *Controller
+		// wantErr is whether the query is expected to stop Run with a
+		// *MatchLimitError rather than nil.
+		wantErr bool
+	}{
+		"fail on match": {
+			c:       &Controller{queryExpr: q, failOnMatch: true},
+			wantErr: true,
+		},
+		"until": {
+			c: &Controller{untilExpr: q, untilPods: 1},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := tt.c
+			c.prefixMode = "never"
+			c.logCh = make(chan *api.Log)
+			c.grouping = true
+			quit, stop := make(chan struct{}), make(chan error, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				c.consumeLog(quit, stop)
+			}()
+			// Both containers have a matching record pending.
+			for _, container := range []string{"app", "sidecar"} {
+				for _, line := range []string{"java.lang.NullPointerException\n", "\tat com.example.App.run(App.java:10)\n"} {
+					c.logCh <- &api.Log{Namespace: "default", Pod: "web-1", Container: container, Content: []byte(line)}
+				}
+			}
+			// The pending records are handled once consumeLog quits.
+			close(quit)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("consumeLog did not return")
+			}
+
+			var err error
+			select {
+			case err = <-stop:
+			default:
+				t.Fatal("the query did not match the pending records")
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("stop = %v, want nil", err)
+				}
+				return
+			}
+			mle, ok := err.(*MatchLimitError)
+			if !ok {
+				t.Fatalf("stop = %v, want *MatchLimitError", err)
+			}
+			want := "java.lang.NullPointerException\n\tat com.example.App.run(App.java:10)\n"
+			if got := string(mle.Matches[0].Content); got != want {
+				t.Errorf("matched %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// maxReportedMatches is the number of matched lines kept for the report of a
+// MatchLimitError.
+const maxReportedMatches = 10
+
+// MatchLimitError is returned by Run when the query matched more lines than
+// the limit given by WithFailOnMatch.
+type MatchLimitError struct {
+	Limit int
+	// Count is the number of matched lines, i.e. Limit+1.
+	Count int
+	// Pods is the number of matched lines of each pod.
+	Pods map[string]int
+	// Matches are the first matched lines, at most maxReportedMatches.
+	Matches []*api.Log
+}
+
+func (e *MatchLimitError) Error() string {
+	return fmt.Sprintf("query matched more than %d lines", e.Limit)
+}
+
+// WriteReport writes a summary of the matched lines and pods to w.
+func (e *MatchLimitError) WriteReport(w io.Writer) {
+	pods := make([]string, 0, len(e.Pods))
+	for pod := range e.Pods {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	_, _ = fmt.Fprintf(w, "%d matched lines by pod:\n", e.Count)
+	for _, pod := range pods {
+		_, _ = fmt.Fprintf(w, "  %s: %d\n", pod, e.Pods[pod])
+	}
+	_, _ = fmt.Fprintf(w, "first %d matched lines:\n", len(e.Matches))
+	for _, l := range e.Matches {
+		content := l.Content
+		if n := len(content); n > 0 && content[n-1] == '\n' {
+			content = content[:n-1]
+		}
+		_, _ = fmt.Fprintf(w, "  %s[%s] %s\n", l.Pod, l.Container, content)
+	}
+}
+
+// matchCounter counts the lines matched by the query for WithFailOnMatch.
+type matchCounter struct {
+	limit   int
+	count   int
+	pods    map[string]int
+	matches []*api.Log
+}
+
+func newMatchCounter(limit int) *matchCounter {
+	return &matchCounter{limit: limit, pods: make(map[string]int)}
+}
+
+// add records a matched line, and returns a *MatchLimitError once more lines
+// than the limit have matched.
+func (m *matchCounter) add(l *api.Log) error {
+	m.count++
+	m.pods[l.Pod]++
+	if len(m.matches) < maxReportedMatches {
+		m.matches = append(m.matches, l)
+	}
+	if m.count <= m.limit {
+		return nil
+	}
+	return &MatchLimitError{Limit: m.limit, Count: m.count, Pods: m.pods, Matches: m.matches}
+}
@@ -2,7 +2,9 @@ package controller
 
 import (
 	"regexp"
+	"text/template"
 
+	"github.com/knight42/kt/pkg/level"
 	"github.com/knight42/kt/pkg/query"
 )
 
@@ -49,3 +51,95 @@ func WithQuery(expr query.Expr) Option {
 		t.queryExpr = expr
 	}
 }
+
+func WithContext(before, after int) Option {
+	return func(t *Controller) {
+		t.beforeContext = before
+		t.afterContext = after
+	}
+}
+
+func WithExcludes(exprs []query.Expr) Option {
+	return func(t *Controller) {
+		t.excludeExprs = exprs
+	}
+}
+
+func WithHighlights(terms []query.Term) Option {
+	return func(t *Controller) {
+		t.highlightTerms = terms
+	}
+}
+
+func WithMinLevel(l level.Level) Option {
+	return func(t *Controller) {
+		t.minLevel = l
+	}
+}
+
+func WithFailOnMatch(limit int) Option {
+	return func(t *Controller) {
+		t.failOnMatch = true
+		t.matchLimit = limit
+	}
+}
+
+func WithUntil(expr query.Expr, pods int) Option {
+	return func(t *Controller) {
+		t.untilExpr = expr
+		t.untilPods = pods
+	}
+}
+
+func WithFields(names []string) Option {
+	return func(t *Controller) {
+		t.fieldNames = names
+	}
+}
+
+func WithOutput(format string) Option {
+	return func(t *Controller) {
+		t.output = format
+	}
+}
+
+func WithTemplate(tmpl *template.Template) Option {
+	return func(t *Controller) {
+		t.output = OutputTemplate
+		t.template = tmpl
+		t.needRevision = t.needRevision || usesRevision(tmpl)
+	}
+}
+
+func WithPrefixFormat(tmpl *template.Template) Option {
+	return func(t *Controller) {
+		t.prefixTemplate = tmpl
+		t.needRevision = t.needRevision || usesRevision(tmpl)
+	}
+}
+
+func WithPrefixLabels(labels []string) Option {
+	return func(t *Controller) {
+		t.prefixLabels = labels
+	}
+}
+
+func WithJSONPretty(mode string) Option {
+	return func(t *Controller) {
+		t.jsonPretty = mode
+	}
+}
+
+func WithTimestampFormat(format string, inPrefix bool) Option {
+	return func(t *Controller) {
+		t.timestampFormat = format
+		t.timestampInPrefix = inPrefix
+	}
+}
+
+func WithGrouping(start *regexp.Regexp) Option {
+	return func(t *Controller) {
+		t.grouping = true
+		t.groupStart = start
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// Output formats which can be given to WithOutput.
+const (
+	OutputText     = "text"
+	OutputJSON     = "json"
+	OutputTemplate = "template"
+)
+
+// content returns the text of i to print, which is Content unless structured
+// logs are projected to some of their fields by WithFields.
+func (c *Controller) content(i *api.Log) []byte {
+	if len(c.fieldNames) > 0 {
+		if fs, ok := i.Fields(); ok {
+			return append([]byte(fs.Project(c.fieldNames)), '\n')
+		}
+	}
+	return i.Content
+}
+
+// jsonLog is a line of the JSON output.
+type jsonLog struct {
+	Namespace string     `json:"namespace"`
+	Pod       string     `json:"pod"`
+	Container string     `json:"container"`
+	Node      string     `json:"node"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// Message is the log as a string, or the log itself if it is a JSON
+	// object.
+	Message any `json:"message"`
+	// Terms are the terms of the query which occur in the log.
+	Terms []string `json:"terms"`
+}
+
+// writeJSON writes i as a JSON object on a single line.
+func (c *Controller) writeJSON(w *bufio.Writer, i *api.Log) {
+	out := jsonLog{
+		Namespace: i.Namespace,
+		Pod:       i.Pod,
+		Container: i.Container,
+		Node:      i.Node,
+		Terms:     []string{},
+	}
+	if !i.Timestamp.IsZero() {
+		out.Timestamp = &i.Timestamp
+	}
+	msg := bytes.TrimRight(i.Content, "\r\n")
+	if trimmed := bytes.TrimSpace(msg); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		out.Message = json.RawMessage(trimmed)
+	} else {
+		out.Message = string(msg)
+	}
+	if c.termMatcher != nil {
+		for _, t := range c.termMatcher.Find(i.Content) {
+			out.Terms = append(out.Terms, t.String())
+		}
+	}
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	_ = e.Encode(out)
+}
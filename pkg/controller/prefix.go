@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/knight42/kt/pkg/api"
+	"github.com/knight42/kt/pkg/log"
+)
+
+// revisionAnnotation is set on ReplicaSets by the Deployment controller.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// podRevision returns the revision of the Deployment which pod belongs to, or
+// an empty string if it does not belong to one. Each ReplicaSet is only looked
+// up once.
+func (c *Controller) podRevision(pod *corev1.Pod) string {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "ReplicaSet" {
+		return ""
+	}
+	key := pod.Namespace + "/" + ref.Name
+	if rev, ok := c.revisions[key]; ok {
+		return rev
+	}
+	rs, err := c.kubeClient.AppsV1().ReplicaSets(pod.Namespace).Get(context.Background(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		log.V(3).Infof(">>>>> [ERROR] get revision of pod %s: %v", pod.Name, err)
+		return ""
+	}
+	if c.revisions == nil {
+		c.revisions = make(map[string]string)
+	}
+	rev := rs.Annotations[revisionAnnotation]
+	c.revisions[key] = rev
+	return rev
+}
+
+// writePrefixTemplate writes the prefix rendered by the template given by
+// WithPrefixFormat, in the color of the pod.
+func (c *Controller) writePrefixTemplate(w *bufio.Writer, i *api.Log) {
+	out, err := executeTemplate(c.prefixTemplate, i, i.Content)
+	if err != nil {
+		log.Errorf("failed to render prefix of %s[%s]: %v", i.Pod, i.Container, err)
+		return
+	}
+	if i.PodColor != nil {
+		_, _ = i.PodColor.Fprint(w, string(out))
+		return
+	}
+	_, _ = w.Write(out)
+}
+
+// writePrefixWithLabels writes the default prefix followed by the labels given
+// by WithPrefixLabels, e.g. web-1[app]{app=web,version=v2}. Labels which the
+// pod does not have are left out.
+func (c *Controller) writePrefixWithLabels(w *bufio.Writer, i *api.Log) {
+	labels := "{"
+	for _, name := range c.prefixLabels {
+		v, ok := i.Labels[name]
+		if !ok {
+			continue
+		}
+		if len(labels) > 1 {
+			labels += ","
+		}
+		labels += name + "=" + v
+	}
+	labels += "}"
+	if labels == "{}" {
+		labels = ""
+	}
+	if i.PodColor != nil {
+		_, _ = i.PodColor.Fprint(w, i.Pod)
+		_, _ = i.ContainerColor.Fprintf(w, "[%s]%s ", i.Container, labels)
+	} else {
+		_, _ = w.WriteString(i.Pod + "[" + i.Container + "]" + labels + " ")
+	}
+}
@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/knight42/kt/pkg/api"
+	"github.com/knight42/kt/pkg/level"
+	"github.com/knight42/kt/pkg/query"
+)
+
+// Modes of WithJSONPretty.
+const (
+	// JSONPrettyCompact renders a JSON log as its level and message followed
+	// by the other fields as key=value.
+	JSONPrettyCompact = "compact"
+	// JSONPrettyIndent renders a JSON log indented over several lines.
+	JSONPrettyIndent = "indent"
+)
+
+// messageKeys are the keys holding the message of a JSON log, shown after the
+// level by JSONPrettyCompact.
+var messageKeys = []string{"msg", "message"}
+
+// Colors of the parts of pretty JSON.
+const (
+	jsonKeyColor     = "\033[34m"
+	jsonStringColor  = "\033[32m"
+	jsonNumberColor  = "\033[33m"
+	jsonLiteralColor = "\033[35m"
+	colorReset       = "\033[0m"
+)
+
+// jsonField is a field of a JSON object. Objects are decoded as []jsonField to
+// keep their keys in order, arrays as []any, and numbers as json.Number.
+type jsonField struct {
+	key   string
+	value any
+}
+
+// decodeJSONObject decodes line as a single JSON object. ok is false if line is
+// anything else, including an object followed by more data.
+func decodeJSONObject(line []byte) (obj []jsonField, ok bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return nil, false
+	}
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	v, err := decodeJSONValue(d)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, false
+	}
+	obj, ok = v.([]jsonField)
+	return obj, ok
+}
+
+func decodeJSONValue(d *json.Decoder) (any, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := []jsonField{}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSONValue(d)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{key: key.(string), value: v})
+		}
+		_, err := d.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for d.More() {
+			v, err := decodeJSONValue(d)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := d.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// jsonPrinter renders JSON logs for WithJSONPretty. Values are highlighted one
+// by one, so terms spanning several values are not highlighted.
+type jsonPrinter struct {
+	mode  string
+	color bool
+	hl    *query.Highlighter
+	b     bytes.Buffer
+}
+
+// renderJSON renders i as configured by WithJSONPretty, with the terms of hl
+// highlighted. ok is false if WithJSONPretty is not given or i is not a JSON
+// object, in which case it should be printed as it is.
+func (c *Controller) renderJSON(i *api.Log, hl *query.Highlighter) (out []byte, ok bool) {
+	if len(c.jsonPretty) == 0 {
+		return nil, false
+	}
+	obj, ok := decodeJSONObject(i.Content)
+	if !ok {
+		return nil, false
+	}
+	p := &jsonPrinter{mode: c.jsonPretty, color: c.enableColor, hl: hl}
+	if c.jsonPretty == JSONPrettyIndent {
+		p.value(obj, "")
+	} else {
+		p.compact(obj, i.Level())
+	}
+	p.b.WriteByte('\n')
+	return p.b.Bytes(), true
+}
+
+// compact writes obj as its level and message followed by the other fields as
+// key=value, like logfmt.
+func (p *jsonPrinter) compact(obj []jsonField, lvl level.Level) {
+	levelIdx, msgIdx := -1, -1
+	for idx, f := range obj {
+		if _, ok := f.value.(string); !ok {
+			continue
+		}
+		if levelIdx < 0 && slices.Contains(level.FieldNames, f.key) {
+			levelIdx = idx
+		} else if msgIdx < 0 && slices.Contains(messageKeys, f.key) {
+			msgIdx = idx
+		}
+	}
+	sep := func() {
+		if p.b.Len() > 0 {
+			p.b.WriteByte(' ')
+		}
+	}
+	if levelIdx >= 0 {
+		p.text(levelColors[lvl], obj[levelIdx].value.(string))
+	}
+	if msgIdx >= 0 {
+		sep()
+		p.text("", obj[msgIdx].value.(string))
+	}
+	for idx, f := range obj {
+		if idx == levelIdx || idx == msgIdx {
+			continue
+		}
+		sep()
+		p.text(jsonKeyColor, f.key)
+		p.b.WriteByte('=')
+		if s, ok := f.value.(string); ok {
+			if len(s) == 0 || strings.ContainsAny(s, " =\"\t\r\n") {
+				s = strconv.Quote(s)
+			}
+			p.text(jsonStringColor, s)
+			continue
+		}
+		p.value(f.value, "")
+	}
+}
+
+// value writes v as JSON. If indent is empty, it is written on a single line,
+// otherwise nested values are indented by two more spaces.
+func (p *jsonPrinter) value(v any, indent string) {
+	switch v := v.(type) {
+	case []jsonField:
+		if len(v) == 0 {
+			p.b.WriteString("{}")
+			return
+		}
+		p.b.WriteByte('{')
+		for idx, f := range v {
+			p.separate(idx, indent)
+			p.text(jsonKeyColor, quoteJSON(f.key))
+			p.b.WriteByte(':')
+			if p.mode == JSONPrettyIndent {
+				p.b.WriteByte(' ')
+			}
+			p.value(f.value, indent+"  ")
+		}
+		p.close(indent, '}')
+	case []any:
+		if len(v) == 0 {
+			p.b.WriteString("[]")
+			return
+		}
+		p.b.WriteByte('[')
+		for idx, e := range v {
+			p.separate(idx, indent)
+			p.value(e, indent+"  ")
+		}
+		p.close(indent, ']')
+	case string:
+		p.text(jsonStringColor, quoteJSON(v))
+	case json.Number:
+		p.text(jsonNumberColor, v.String())
+	case bool:
+		p.text(jsonLiteralColor, strconv.FormatBool(v))
+	case nil:
+		p.text(jsonLiteralColor, "null")
+	}
+}
+
+// separate writes what comes before the idx-th element of an object or array.
+func (p *jsonPrinter) separate(idx int, indent string) {
+	if idx > 0 {
+		p.b.WriteByte(',')
+	}
+	if p.mode == JSONPrettyIndent {
+		p.b.WriteString("\n" + indent + "  ")
+	}
+}
+
+func (p *jsonPrinter) close(indent string, delim byte) {
+	if p.mode == JSONPrettyIndent {
+		p.b.WriteString("\n" + indent)
+	}
+	p.b.WriteByte(delim)
+}
+
+// text writes s highlighted and in color, unless colors are disabled or color
+// is empty. The color is restored after every highlighted term.
+func (p *jsonPrinter) text(color string, s string) {
+	out := []byte(s)
+	if p.hl != nil {
+		out = p.hl.Highlight(out)
+	}
+	if !p.color || len(color) == 0 {
+		p.b.Write(out)
+		return
+	}
+	p.b.WriteString(color)
+	p.b.Write(bytes.ReplaceAll(out, []byte(colorReset), []byte(colorReset+color)))
+	p.b.WriteString(colorReset)
+}
+
+// quoteJSON returns s as a JSON string, without escaping HTML characters.
+func quoteJSON(s string) string {
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	_ = e.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+	"github.com/knight42/kt/pkg/fields"
+	"github.com/knight42/kt/pkg/level"
+	"github.com/knight42/kt/pkg/log"
+)
+
+// templateLog is the data a template given by WithTemplate is executed with.
+type templateLog struct {
+	Namespace string
+	Pod       string
+	Container string
+	Node      string
+	Labels    map[string]string
+	Revision  string
+	Time      time.Time
+	Level     level.Level
+	// Message is the log without the trailing newline, with the terms of
+	// the query highlighted.
+	Message string
+	// Fields are the fields of the log if it is structured, or nil.
+	Fields fields.Fields
+}
+
+// levelColors are the colors of the levels used by colorByLevel.
+var levelColors = map[level.Level]string{
+	level.Trace: "\033[90m",
+	level.Debug: "\033[36m",
+	level.Info:  "\033[32m",
+	level.Warn:  "\033[33m",
+	level.Error: "\033[31m",
+	level.Fatal: "\033[1;31m",
+}
+
+// podHashSuffix matches the name of a pod created by a Deployment, or by a Job
+// of a CronJob, whose middle part is the hash of the pod template or the
+// scheduled time. Generated names only use consonants and some digits.
+var podHashSuffix = regexp.MustCompile(`^(.+)-(?:[bcdfghjklmnpqrstvwxz2-9]{6,10}|\d{8,})-([bcdfghjklmnpqrstvwxz2-9]{5})$`)
+
+// shortPodName drops the hash of the ReplicaSet from the name of a pod, e.g.
+// web-7d9f8c6b5-x2x4z becomes web-x2x4z.
+func shortPodName(name string) string {
+	return podHashSuffix.ReplaceAllString(name, "$1-$2")
+}
+
+// templateFuncs are the helper functions of templates. colorByLevel does not
+// color anything unless colors are enabled, see withColors.
+var templateFuncs = template.FuncMap{
+	"short": shortPodName,
+	"colorByLevel": func(_ level.Level, s string) string {
+		return s
+	},
+	"field": func(path string, l *templateLog) string {
+		v, ok := l.Fields.Lookup(path)
+		if !ok {
+			return ""
+		}
+		return fields.Format(v)
+	},
+}
+
+// ParseTemplate parses the text of a template for WithTemplate. It is executed
+// once with an empty log, so that references to missing fields or functions
+// are reported here rather than for every log.
+func ParseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, &templateLog{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// withColors makes colorByLevel of t color the text.
+func withColors(t *template.Template) {
+	t.Funcs(template.FuncMap{
+		"colorByLevel": func(l level.Level, s string) string {
+			c, ok := levelColors[l]
+			if !ok {
+				return s
+			}
+			return c + s + "\033[0m"
+		},
+	})
+}
+
+// usesRevision reports whether t refers to the revision of the pod, which has
+// to be looked up.
+func usesRevision(t *template.Template) bool {
+	return t.Tree != nil && strings.Contains(t.Tree.Root.String(), ".Revision")
+}
+
+// executeTemplate renders i with t. msg is the content of i to render as the
+// message.
+func executeTemplate(t *template.Template, i *api.Log, msg []byte) ([]byte, error) {
+	fs, _ := i.Fields()
+	data := &templateLog{
+		Namespace: i.Namespace,
+		Pod:       i.Pod,
+		Container: i.Container,
+		Node:      i.Node,
+		Labels:    i.Labels,
+		Revision:  i.Revision,
+		Time:      i.Timestamp,
+		Level:     i.Level(),
+		Message:   string(bytes.TrimRight(msg, "\r\n")),
+		Fields:    fs,
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTemplate writes i rendered by the template given by WithTemplate. msg is
+// the content of i to render as the message.
+func (c *Controller) writeTemplate(w *bufio.Writer, i *api.Log, msg []byte) {
+	out, err := executeTemplate(c.template, i, msg)
+	if err != nil {
+		log.Errorf("failed to render log of %s[%s]: %v", i.Pod, i.Container, err)
+		return
+	}
+	if n := len(out); n == 0 || out[n-1] != '\n' {
+		out = append(out, '\n')
+	}
+	_, _ = w.Write(out)
+}
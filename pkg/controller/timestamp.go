@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// Formats of timestamps which can be given to WithTimestampFormat, besides Go
+// layouts.
+const (
+	// TimestampLocal is the time in the local time zone with milliseconds.
+	TimestampLocal = "local"
+	// TimestampRelative is the time since kt started, e.g. +1.204s.
+	TimestampRelative = "relative"
+	// TimestampDelta is the time since the previous printed line.
+	TimestampDelta = "delta"
+)
+
+const localTimestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// defaultTimestampLayout is the layout of the timestamps recorded by the API
+// server. Unlike time.RFC3339Nano it keeps trailing zeros, so that timestamps
+// line up.
+const defaultTimestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatTimestamp renders the timestamp of i as configured by
+// WithTimestampFormat. It returns an empty string if timestamps are not
+// requested or i has none.
+func (c *Controller) formatTimestamp(i *api.Log) string {
+	if c.logsOptions == nil || !c.logsOptions.Timestamps || i.Timestamp.IsZero() {
+		return ""
+	}
+	switch c.timestampFormat {
+	case "":
+		return i.Timestamp.Format(defaultTimestampLayout)
+	case TimestampLocal:
+		return i.Timestamp.Local().Format(localTimestampLayout)
+	case TimestampRelative:
+		return formatOffset(i.Timestamp.Sub(c.startTime))
+	case TimestampDelta:
+		var d time.Duration
+		if !c.lastTimestamp.IsZero() {
+			d = i.Timestamp.Sub(c.lastTimestamp)
+		}
+		c.lastTimestamp = i.Timestamp
+		return formatOffset(d)
+	}
+	return i.Timestamp.Format(c.timestampFormat)
+}
+
+// formatOffset renders d in seconds with a sign and milliseconds, e.g. +1.204s.
+// Lines may be logged before kt started or out of order between containers, so
+// d can be negative.
+func formatOffset(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	return fmt.Sprintf("%s%d.%03ds", sign, d/time.Second, (d%time.Second)/time.Millisecond)
+}
+
+// writeTimestamp writes ts at the start of the prefix, in the color of the pod.
+func (c *Controller) writeTimestamp(w *bufio.Writer, i *api.Log, ts string) {
+	if i.PodColor != nil {
+		_, _ = i.PodColor.Fprint(w, ts+" ")
+		return
+	}
+	_, _ = w.WriteString(ts + " ")
+}
@@ -0,0 +1,85 @@
+// Package fields decodes structured log lines into key/value pairs.
+package fields
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Fields is a decoded structured log line.
+type Fields map[string]any
+
+// Parse decodes line as a JSON object, or as logfmt if it does not look like
+// JSON. ok is false if line is neither. Numbers in JSON are decoded as
+// json.Number to keep their exact representation.
+func Parse(line []byte) (f Fields, ok bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, false
+	}
+	if line[0] != '{' {
+		return parseLogfmt(line)
+	}
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	if err := d.Decode(&f); err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// Lookup returns the value at path, which is either a key or dot-separated keys
+// into nested objects, e.g. user.id. A key which itself contains dots takes
+// precedence over nested objects.
+func (f Fields) Lookup(path string) (any, bool) {
+	if v, ok := f[path]; ok {
+		return v, true
+	}
+	key, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil, false
+	}
+	nested, ok := f[key].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return Fields(nested).Lookup(rest)
+}
+
+// Project renders the values at paths, separated by spaces and in that order.
+// Missing values are rendered as - and empty ones as "".
+func (f Fields) Project(paths []string) string {
+	var b strings.Builder
+	for i, path := range paths {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		v, ok := f.Lookup(path)
+		switch s := Format(v); {
+		case !ok:
+			b.WriteByte('-')
+		case len(s) == 0:
+			b.WriteString(`""`)
+		default:
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// Format renders a value of Fields as text: strings as they are, and other
+// values like numbers or nested objects as compact JSON.
+func Format(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
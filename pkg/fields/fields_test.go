@@ -0,0 +1,164 @@
+package fields
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		line   string
+		wantOK bool
+	}{
+		"object":             {line: `{"level":"error"}` + "\n", wantOK: true},
+		"leading whitespace": {line: `  {"level":"error"}`, wantOK: true},
+		"logfmt":             {line: "level=error msg=boom", wantOK: true},
+		"plain text":         {line: "connection reset by peer", wantOK: false},
+		"array":              {line: `[1,2]`, wantOK: false},
+		"truncated":          {line: `{"level":`, wantOK: false},
+		"empty":              {line: "", wantOK: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, ok := Parse([]byte(tt.line))
+			if ok != tt.wantOK {
+				t.Errorf("Parse(%q) ok = %v, want %v", tt.line, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	f, ok := Parse([]byte(`{"level":"info","status":500,"user":{"id":"42","org":{"name":"acme"}},"http.method":"GET"}`))
+	if !ok {
+		t.Fatal("Parse failed")
+	}
+	tests := map[string]struct {
+		path   string
+		want   any
+		wantOK bool
+	}{
+		"top level":        {path: "level", want: "info", wantOK: true},
+		"number":           {path: "status", want: json.Number("500"), wantOK: true},
+		"nested":           {path: "user.id", want: "42", wantOK: true},
+		"deeply nested":    {path: "user.org.name", want: "acme", wantOK: true},
+		"dotted key":       {path: "http.method", want: "GET", wantOK: true},
+		"missing":          {path: "trace_id", wantOK: false},
+		"missing nested":   {path: "user.email", wantOK: false},
+		"through a scalar": {path: "level.name", wantOK: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := f.Lookup(tt.path)
+			if ok != tt.wantOK {
+				t.Fatalf("Lookup(%q) ok = %v, want %v", tt.path, ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("Lookup(%q) = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	tests := map[string]struct {
+		line   string
+		want   Fields
+		wantOK bool
+	}{
+		"pairs": {
+			line:   "level=warn duration=1.2s status=503\n",
+			want:   Fields{"level": "warn", "duration": "1.2s", "status": "503"},
+			wantOK: true,
+		},
+		"quoted value": {
+			line:   `level=info msg="no route to \"host\"" err=`,
+			want:   Fields{"level": "info", "msg": `no route to "host"`, "err": ""},
+			wantOK: true,
+		},
+		"bare words are skipped": {
+			line:   "GET /api/v1 status=503",
+			want:   Fields{"status": "503"},
+			wantOK: true,
+		},
+		"no pairs": {
+			line:   "panic: runtime error",
+			wantOK: false,
+		},
+		"unterminated quote": {
+			line:   `msg="oops`,
+			wantOK: false,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := Parse([]byte(tt.line))
+			if ok != tt.wantOK {
+				t.Fatalf("Parse(%q) ok = %v, want %v", tt.line, ok, tt.wantOK)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Parse(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("Parse(%q)[%q] = %#v, want %#v", tt.line, k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestProject(t *testing.T) {
+	tests := map[string]struct {
+		line  string
+		paths []string
+		want  string
+	}{
+		"json": {
+			line:  `{"ts":"2026-10-18T10:00:00Z","level":"error","msg":"request failed","err":"timeout","status":503}`,
+			paths: []string{"ts", "level", "msg", "err"},
+			want:  "2026-10-18T10:00:00Z error request failed timeout",
+		},
+		"order and missing keys": {
+			line:  `{"level":"info","msg":"ok"}`,
+			paths: []string{"msg", "err", "level"},
+			want:  "ok - info",
+		},
+		"non-string values": {
+			line:  `{"status":503,"ok":false,"user":{"id":"42"},"tags":["a","b"],"trace":null}`,
+			paths: []string{"status", "ok", "user", "tags", "trace"},
+			want:  `503 false {"id":"42"} ["a","b"] null`,
+		},
+		"html is not escaped": {
+			line:  `{"req":{"url":"/a?b=1&c=<2>"}}`,
+			paths: []string{"req"},
+			want:  `{"url":"/a?b=1&c=<2>"}`,
+		},
+		"nested path": {
+			line:  `{"user":{"id":"42"}}`,
+			paths: []string{"user.id"},
+			want:  "42",
+		},
+		"logfmt": {
+			line:  `level=warn msg="slow query" duration=1.2s err=`,
+			paths: []string{"level", "msg", "err", "duration"},
+			want:  `warn slow query "" 1.2s`,
+		},
+		"large number keeps its representation": {
+			line:  `{"id":12345678901234567890}`,
+			paths: []string{"id"},
+			want:  "12345678901234567890",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, ok := Parse([]byte(tt.line))
+			if !ok {
+				t.Fatalf("Parse(%q) failed", tt.line)
+			}
+			if got := f.Project(tt.paths); got != tt.want {
+				t.Errorf("Project() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
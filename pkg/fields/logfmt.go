@@ -0,0 +1,70 @@
+package fields
+
+import (
+	"strconv"
+)
+
+// parseLogfmt decodes line as logfmt, e.g. level=warn duration=1.2s msg="no route".
+// Values are kept as strings. Bare words without a value are skipped, so
+// semi-structured lines like `GET /api status=503` still yield their pairs.
+// ok is false if line contains no key/value pair or a malformed quoted value.
+func parseLogfmt(line []byte) (Fields, bool) {
+	var f Fields
+	i := 0
+	for i < len(line) {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		start := i
+		for i < len(line) && !isSpace(line[i]) && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' || len(key) == 0 {
+			// A bare word, skip it.
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			continue
+		}
+		i++ // skip '='
+		var value string
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			s, err := strconv.Unquote(string(line[i : end+1]))
+			if err != nil {
+				return nil, false
+			}
+			value = s
+			i = end + 1
+		} else {
+			start = i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			value = string(line[start:i])
+		}
+		if f == nil {
+			f = make(Fields)
+		}
+		f[string(key)] = value
+	}
+	return f, f != nil
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n':
+		return true
+	}
+	return false
+}
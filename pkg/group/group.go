@@ -0,0 +1,182 @@
+// Package group joins the lines of multi-line logs, like stack traces, into
+// single records.
+package group
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// MaxLines is the maximum number of lines of a record. Once it is reached, the
+// record is complete and the next line starts a new one, so that a rule
+// matching too much does not hold on to a container forever.
+const MaxLines = 1000
+
+var (
+	goroutineHeader = regexp.MustCompile(`^goroutine \d+ \[`)
+	// goFrame matches the lines of a goroutine dump which are not indented:
+	// the calls, e.g. main.main() or net/http.(*conn).serve(0xc000...),
+	// and where goroutines were created.
+	goFrame = regexp.MustCompile(`^(?:created by |\.\.\.|\S+\(.*\)$)`)
+)
+
+// mode is the kind of record being grouped, which decides which lines
+// continue it besides indented ones.
+type mode int
+
+const (
+	modeDefault mode = iota
+	// modeTraceback is a Python traceback, which ends with the first line
+	// which is not indented, e.g. ValueError: bad value.
+	modeTraceback
+	// modeGoroutine is a Go panic or goroutine dump, whose goroutines are
+	// separated by empty lines.
+	modeGoroutine
+)
+
+// Grouper joins the lines of each container into records. By default, a line
+// continues the record of the previous line of its container if it is
+// indented, starts with "Caused by:", or belongs to a Python traceback, a Go
+// panic or a goroutine dump. Lines must be added in the order they are logged.
+type Grouper struct {
+	start   *regexp.Regexp
+	streams map[string]*record
+}
+
+// record holds the lines of a container which are not complete yet.
+type record struct {
+	lines []*api.Log
+	mode  mode
+	// ended is set once a Python traceback has reached its last line.
+	ended bool
+	// updated is when the last line was added.
+	updated time.Time
+}
+
+// New creates a Grouper. If start is not nil, it replaces the default rules: a
+// line starts a new record if it matches start, and continues the previous
+// record otherwise.
+func New(start *regexp.Regexp) *Grouper {
+	return &Grouper{start: start, streams: make(map[string]*record)}
+}
+
+// Add adds l, which was received at now. It returns the previous record of the
+// container if l starts a new one, or nil.
+func (g *Grouper) Add(l *api.Log, now time.Time) *api.Log {
+	key := l.Stream()
+	rec := g.streams[key]
+	if rec != nil && len(rec.lines) < MaxLines && g.continues(rec, trimNewline(l.Content)) {
+		rec.lines = append(rec.lines, l)
+		rec.updated = now
+		return nil
+	}
+	g.streams[key] = g.newRecord(l, now)
+	if rec == nil {
+		return nil
+	}
+	return rec.join()
+}
+
+// Flush removes and returns the records which were last updated before
+// deadline, in the order they were updated. Since a record is only known to be
+// complete once the next line of its container is logged, records have to be
+// flushed once their container has been quiet for a while.
+func (g *Grouper) Flush(deadline time.Time) []*api.Log {
+	var recs []*record
+	for key, rec := range g.streams {
+		if rec.updated.Before(deadline) {
+			recs = append(recs, rec)
+			delete(g.streams, key)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].updated.Before(recs[j].updated)
+	})
+	logs := make([]*api.Log, 0, len(recs))
+	for _, rec := range recs {
+		logs = append(logs, rec.join())
+	}
+	return logs
+}
+
+func (g *Grouper) newRecord(l *api.Log, now time.Time) *record {
+	rec := &record{lines: []*api.Log{l}, updated: now}
+	line := trimNewline(l.Content)
+	switch {
+	case g.start != nil:
+	case bytes.HasPrefix(line, []byte("Traceback ")):
+		rec.mode = modeTraceback
+	case bytes.HasPrefix(line, []byte("panic: ")),
+		bytes.HasPrefix(line, []byte("fatal error: ")),
+		goroutineHeader.Match(line):
+		rec.mode = modeGoroutine
+	}
+	return rec
+}
+
+// continues reports whether line belongs to rec rather than starting a new
+// record.
+func (g *Grouper) continues(rec *record, line []byte) bool {
+	if g.start != nil {
+		return !g.start.Match(line)
+	}
+	if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		return true
+	}
+	if bytes.HasPrefix(line, []byte("Caused by:")) {
+		return true
+	}
+	switch rec.mode {
+	case modeTraceback:
+		if rec.ended {
+			return false
+		}
+		rec.ended = true
+		return len(line) > 0
+	case modeGoroutine:
+		if len(line) == 0 || goroutineHeader.Match(line) {
+			return true
+		}
+		// A goroutine ends with an empty line, after which only the
+		// header of another goroutine continues the record.
+		last := trimNewline(rec.lines[len(rec.lines)-1].Content)
+		return len(last) > 0 && goFrame.Match(line)
+	}
+	return false
+}
+
+// join returns the lines of rec as a single log, which has the metadata and
+// the timestamp of the first line.
+func (rec *record) join() *api.Log {
+	first := rec.lines[0]
+	if len(rec.lines) == 1 {
+		return first
+	}
+	var content []byte
+	for _, l := range rec.lines {
+		content = append(content, l.Content...)
+		if n := len(content); n > 0 && content[n-1] != '\n' {
+			content = append(content, '\n')
+		}
+	}
+	return &api.Log{
+		Namespace:      first.Namespace,
+		Pod:            first.Pod,
+		Container:      first.Container,
+		Node:           first.Node,
+		Labels:         first.Labels,
+		Revision:       first.Revision,
+		Content:        content,
+		Timestamp:      first.Timestamp,
+		PodColor:       first.PodColor,
+		ContainerColor: first.ContainerColor,
+	}
+}
+
+func trimNewline(b []byte) []byte {
+	return bytes.TrimRight(b, "\r\n")
+}
@@ -0,0 +1,152 @@
+package group
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// groupLines adds lines to a Grouper in order as logs of a single container,
+// and returns the contents of the records.
+func groupLines(g *Grouper, lines []string) []string {
+	now := time.Now()
+	var got []string
+	for _, line := range lines {
+		if rec := g.Add(&api.Log{Pod: "web", Container: "app", Content: []byte(line + "\n")}, now); rec != nil {
+			got = append(got, string(rec.Content))
+		}
+	}
+	for _, rec := range g.Flush(now.Add(time.Second)) {
+		got = append(got, string(rec.Content))
+	}
+	return got
+}
+
+func TestGrouper(t *testing.T) {
+	tests := map[string]struct {
+		lines []string
+		want  []string
+	}{
+		"single lines": {
+			lines: []string{"a", "b"},
+			want:  []string{"a\n", "b\n"},
+		},
+		"java": {
+			lines: []string{
+				"java.lang.IllegalStateException: failed",
+				"\tat com.example.App.run(App.java:10)",
+				"Caused by: java.lang.NullPointerException",
+				"\tat com.example.Db.get(Db.java:42)",
+				"\t... 3 more",
+				"next",
+			},
+			want: []string{
+				"java.lang.IllegalStateException: failed\n\tat com.example.App.run(App.java:10)\nCaused by: java.lang.NullPointerException\n\tat com.example.Db.get(Db.java:42)\n\t... 3 more\n",
+				"next\n",
+			},
+		},
+		"python": {
+			lines: []string{
+				"Traceback (most recent call last):",
+				`  File "app.py", line 3, in <module>`,
+				"    main()",
+				"ValueError: bad value",
+				"next",
+			},
+			want: []string{
+				"Traceback (most recent call last):\n  File \"app.py\", line 3, in <module>\n    main()\nValueError: bad value\n",
+				"next\n",
+			},
+		},
+		"go panic": {
+			lines: []string{
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/src/main.go:8 +0x1d",
+				"",
+				"goroutine 7 [chan receive]:",
+				"created by main.main in goroutine 1",
+				"exit status 2",
+			},
+			want: []string{
+				"panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/src/main.go:8 +0x1d\n\ngoroutine 7 [chan receive]:\ncreated by main.main in goroutine 1\n",
+				"exit status 2\n",
+			},
+		},
+		"go frame after empty line": {
+			lines: []string{"goroutine 1 [running]:", "main.main()", "", "main.f()"},
+			want:  []string{"goroutine 1 [running]:\nmain.main()\n\n", "main.f()\n"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := groupLines(New(nil), tt.lines)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrouper_Start(t *testing.T) {
+	g := New(regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `))
+	got := groupLines(g, []string{
+		"continued before any start",
+		"2026-10-18 10:00:00 ERROR failed",
+		"not indented detail",
+		"2026-10-18 10:00:01 INFO ok",
+	})
+	want := []string{
+		"continued before any start\n",
+		"2026-10-18 10:00:00 ERROR failed\nnot indented detail\n",
+		"2026-10-18 10:00:01 INFO ok\n",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+func TestGrouper_Containers(t *testing.T) {
+	g := New(nil)
+	now := time.Now()
+	line := func(container, content string) *api.Log {
+		return &api.Log{Pod: "web", Container: container, Content: []byte(content + "\n")}
+	}
+	for _, l := range []*api.Log{
+		line("app", "java.lang.NullPointerException"),
+		line("sidecar", "unrelated"),
+		line("app", "\tat com.example.App.run(App.java:10)"),
+	} {
+		if rec := g.Add(l, now); rec != nil {
+			t.Fatalf("Add() = %q before the next line of the container", rec.Content)
+		}
+	}
+	if got := g.Flush(now); len(got) != 0 {
+		t.Errorf("Flush() = %d records updated at the deadline, want none", len(got))
+	}
+	got := g.Flush(now.Add(time.Millisecond))
+	if len(got) != 2 {
+		t.Fatalf("Flush() = %d records, want 2", len(got))
+	}
+	for _, rec := range got {
+		if rec.Container == "app" && string(rec.Content) != "java.lang.NullPointerException\n\tat com.example.App.run(App.java:10)\n" {
+			t.Errorf("record of app = %q", rec.Content)
+		}
+	}
+}
+
+func TestGrouper_MaxLines(t *testing.T) {
+	lines := []string{"Exception"}
+	for range MaxLines {
+		lines = append(lines, "\tat x")
+	}
+	got := groupLines(New(nil), lines)
+	if len(got) != 2 || strings.Count(got[0], "\n") != MaxLines {
+		t.Errorf("got %d records, want the first with %d lines", len(got), MaxLines)
+	}
+}
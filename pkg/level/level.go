@@ -0,0 +1,196 @@
+package level
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/knight42/kt/pkg/fields"
+)
+
+// Level is the severity of a log line, ordered from the least to the most
+// severe.
+type Level int
+
+const (
+	Unknown Level = iota
+	Trace
+	Debug
+	Info
+	Warn
+	Error
+	Fatal
+)
+
+var names = [...]string{
+	Unknown: "unknown",
+	Trace:   "trace",
+	Debug:   "debug",
+	Info:    "info",
+	Warn:    "warn",
+	Error:   "error",
+	Fatal:   "fatal",
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(names) {
+		return names[Unknown]
+	}
+	return names[l]
+}
+
+// aliases maps the lower case spellings of severities found in logs to their
+// levels.
+var aliases = map[string]Level{
+	"trace":    Trace,
+	"debug":    Debug,
+	"dbg":      Debug,
+	"info":     Info,
+	"notice":   Info,
+	"warn":     Warn,
+	"warning":  Warn,
+	"error":    Error,
+	"err":      Error,
+	"fatal":    Fatal,
+	"critical": Fatal,
+	"crit":     Fatal,
+	"panic":    Fatal,
+}
+
+// Parse parses the name of a level given by the user, e.g. warn.
+func Parse(s string) (Level, error) {
+	l, ok := aliases[strings.ToLower(s)]
+	if !ok {
+		return Unknown, fmt.Errorf("invalid level %q, must be one of trace, debug, info, warn, error or fatal", s)
+	}
+	return l, nil
+}
+
+// FieldNames are the keys holding the severity of a structured log.
+var FieldNames = []string{"level", "severity", "lvl"}
+
+// bracketWindow is how far into a line a bracketed severity like [WARN] is
+// looked for, so that brackets in the message itself are not mistaken for it.
+const bracketWindow = 64
+
+// Detect returns the severity of a log line, given its content and its fields
+// if it is structured. It recognizes, in order:
+//
+//   - a level or severity field of a JSON or logfmt log, which may be a
+//     number like 50 as logged by pino and bunyan
+//   - a klog header like E1018 10:00:00.000000
+//   - a Python logging prefix like ERROR:root:
+//   - a bracketed severity like [WARN] near the start of the line
+//
+// It returns Unknown if none of them is found.
+func Detect(content []byte, fs fields.Fields) Level {
+	if fs != nil {
+		for _, name := range FieldNames {
+			if v, ok := fs.Lookup(name); ok {
+				switch v := v.(type) {
+				case string:
+					return aliases[strings.ToLower(v)]
+				case json.Number:
+					return numeric(v)
+				}
+				return Unknown
+			}
+		}
+	}
+	if l := klog(content); l != Unknown {
+		return l
+	}
+	if l := python(content); l != Unknown {
+		return l
+	}
+	return bracketed(content)
+}
+
+// numeric maps the numeric levels of pino and bunyan, from 10 for trace to 60
+// for fatal, to a Level. Custom levels in between round down. Smaller numbers,
+// e.g. syslog severities, are not recognized.
+func numeric(n json.Number) Level {
+	v, err := n.Int64()
+	switch {
+	case err != nil || v < 10:
+		return Unknown
+	case v < 20:
+		return Trace
+	case v < 30:
+		return Debug
+	case v < 40:
+		return Info
+	case v < 50:
+		return Warn
+	case v < 60:
+		return Error
+	}
+	return Fatal
+}
+
+// klog detects the header of klog and glog, Lmmdd followed by a space.
+func klog(content []byte) Level {
+	if len(content) < 6 || content[5] != ' ' {
+		return Unknown
+	}
+	for _, b := range content[1:5] {
+		if b < '0' || b > '9' {
+			return Unknown
+		}
+	}
+	switch content[0] {
+	case 'I':
+		return Info
+	case 'W':
+		return Warn
+	case 'E':
+		return Error
+	case 'F':
+		return Fatal
+	}
+	return Unknown
+}
+
+// python detects the default format of the logging module, LEVEL:logger:msg.
+func python(content []byte) Level {
+	name, _, found := bytes.Cut(content, []byte(":"))
+	if !found {
+		return Unknown
+	}
+	switch string(name) {
+	case "DEBUG":
+		return Debug
+	case "INFO":
+		return Info
+	case "WARNING":
+		return Warn
+	case "ERROR":
+		return Error
+	case "CRITICAL":
+		return Fatal
+	}
+	return Unknown
+}
+
+func bracketed(content []byte) Level {
+	if len(content) > bracketWindow {
+		content = content[:bracketWindow]
+	}
+	for {
+		start := bytes.IndexByte(content, '[')
+		if start < 0 {
+			return Unknown
+		}
+		content = content[start+1:]
+		end := bytes.IndexByte(content, ']')
+		if end < 0 {
+			return Unknown
+		}
+		name := strings.TrimSpace(string(content[:end]))
+		if l, ok := aliases[strings.ToLower(name)]; ok {
+			return l
+		}
+		content = content[end+1:]
+	}
+}
@@ -0,0 +1,79 @@
+package level
+
+import (
+	"testing"
+
+	"github.com/knight42/kt/pkg/fields"
+)
+
+func TestDetect(t *testing.T) {
+	tests := map[string]struct {
+		line string
+		want Level
+	}{
+		"json level":             {line: `{"level":"warn","msg":"slow"}`, want: Warn},
+		"json severity":          {line: `{"severity":"ERROR","message":"boom"}`, want: Error},
+		"json level wins":        {line: `{"msg":"[ERROR] in message","level":"info"}`, want: Info},
+		"json unknown level":     {line: `{"level":"verbose"}`, want: Unknown},
+		"json numeric level":     {line: `{"level":50}`, want: Error},
+		"json numeric trace":     {line: `{"level":10,"msg":"enter"}`, want: Trace},
+		"json numeric custom":    {line: `{"level":35}`, want: Info},
+		"json numeric fatal":     {line: `{"level":60}`, want: Fatal},
+		"json small number":      {line: `{"level":3}`, want: Unknown},
+		"json fractional level":  {line: `{"level":30.5}`, want: Unknown},
+		"json without level":     {line: `{"msg":"hello"}`, want: Unknown},
+		"logfmt":                 {line: `ts=2026-10-18T10:00:00Z level=debug msg="cache miss"`, want: Debug},
+		"logfmt quoted":          {line: `level="warning" msg=retrying`, want: Warn},
+		"klog error":             {line: "E1018 10:00:00.123456       1 controller.go:42] sync failed", want: Error},
+		"klog info":              {line: "I1018 10:00:00.123456       1 main.go:10] started", want: Info},
+		"klog warning":           {line: "W0102 03:04:05.000000       7 reflector.go:1] watch closed", want: Warn},
+		"klog fatal":             {line: "F1018 10:00:00.000000       1 main.go:1] exiting", want: Fatal},
+		"not klog":               {line: "E10 is a model number", want: Unknown},
+		"python error":           {line: "ERROR:root:connection refused", want: Error},
+		"python warning":         {line: "WARNING:urllib3.connectionpool:Retrying", want: Warn},
+		"python critical":        {line: "CRITICAL:app:disk full", want: Fatal},
+		"python is upper case":   {line: "error: not a python log", want: Unknown},
+		"bracketed":              {line: "2026-10-18 10:00:00 [WARN] pool exhausted", want: Warn},
+		"bracketed lower case":   {line: "[error] boom", want: Error},
+		"bracketed with padding": {line: "10:00:00 [ INFO ] ready", want: Info},
+		"other brackets first":   {line: "[main] [ERROR] boom", want: Error},
+		"bracketed far away":     {line: "GET /api/v1/namespaces/default/pods?watch=true&resourceVersion=42 [ERROR]", want: Unknown},
+		"plain":                  {line: "something went wrong with the error handler", want: Unknown},
+		"empty":                  {line: "", want: Unknown},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs, _ := fields.Parse([]byte(tt.line))
+			if got := Detect([]byte(tt.line), fs); got != tt.want {
+				t.Errorf("Detect(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		want    Level
+		wantErr bool
+	}{
+		"warn":        {input: "warn", want: Warn},
+		"warning":     {input: "WARNING", want: Warn},
+		"err":         {input: "err", want: Error},
+		"critical":    {input: "critical", want: Fatal},
+		"invalid":     {input: "loud", wantErr: true},
+		"empty":       {input: "", wantErr: true},
+		"not unknown": {input: "unknown", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package query
+
+// automaton is an Aho–Corasick automaton which finds all occurrences of a set
+// of patterns in a single pass, ignoring case under simple case folding.
+//
+// Both the patterns and the text are folded rune by rune with foldRune before
+// matching. Text which is pure ASCII, by far the most common, is matched as is
+// since the automaton ignores ASCII case by itself.
+type automaton struct {
+	// class maps a byte to its column in next. Bytes which do not occur in
+	// any pattern share the column 0.
+	class [256]uint8
+	// next is the transition table of the deterministic automaton, i.e.
+	// failure transitions are already resolved.
+	next [][]int32
+	// out holds the patterns ending at each state.
+	out [][]int
+	// lens holds the length of each folded pattern.
+	lens []int
+}
+
+func toLowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// newAutomaton builds an automaton for the given non-empty patterns.
+func newAutomaton(patterns [][]byte) *automaton {
+	a := &automaton{lens: make([]int, len(patterns))}
+	folded := make([][]byte, len(patterns))
+	for i, p := range patterns {
+		folded[i] = fold(p)
+	}
+	patterns = folded
+	width := 1
+	for _, p := range patterns {
+		for _, b := range p {
+			lb := toLowerASCII(b)
+			if a.class[lb] == 0 {
+				// Upper case letters are lowered, so there are at most 230
+				// distinct bytes and they fit in a uint8.
+				a.class[lb] = uint8(width)
+				width++
+			}
+		}
+	}
+	for b := 'A'; b <= 'Z'; b++ {
+		a.class[b] = a.class[b+'a'-'A']
+	}
+
+	newState := func() int32 {
+		row := make([]int32, width)
+		for i := range row {
+			row[i] = -1
+		}
+		a.next = append(a.next, row)
+		a.out = append(a.out, nil)
+		return int32(len(a.next) - 1)
+	}
+	newState()
+
+	for idx, p := range patterns {
+		a.lens[idx] = len(p)
+		var s int32
+		for _, b := range p {
+			c := a.class[b]
+			if a.next[s][c] < 0 {
+				n := newState()
+				a.next[s][c] = n
+			}
+			s = a.next[s][c]
+		}
+		a.out[s] = append(a.out[s], idx)
+	}
+
+	// Resolve failure transitions breadth-first, so that the failure state
+	// of every state, being shallower, is complete before the state itself.
+	fail := make([]int32, len(a.next))
+	var queue []int32
+	for c := range a.next[0] {
+		if n := a.next[0][c]; n >= 0 {
+			queue = append(queue, n)
+		} else {
+			a.next[0][c] = 0
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if f := fail[s]; len(a.out[f]) > 0 {
+			a.out[s] = append(a.out[s], a.out[f]...)
+		}
+		for c, n := range a.next[s] {
+			if n < 0 {
+				a.next[s][c] = a.next[fail[s]][c]
+				continue
+			}
+			fail[n] = a.next[fail[s]][c]
+			queue = append(queue, n)
+		}
+	}
+	return a
+}
+
+// scan calls fn with the index of the pattern and the [start, end) offsets of
+// every occurrence of the patterns in text, including overlapping ones. It
+// stops once fn returns false.
+func (a *automaton) scan(text []byte, fn func(pattern, start, end int) bool) {
+	if isASCII(text) {
+		a.scanFolded(text, func(p, end int) bool {
+			return fn(p, end-a.lens[p], end)
+		})
+		return
+	}
+	folded, offsets := foldWithOffsets(text, true)
+	a.scanFolded(folded, func(p, end int) bool {
+		return fn(p, offsets[end-a.lens[p]], offsets[end])
+	})
+}
+
+// scanFolded calls fn with the index of the pattern and the end offset of
+// every occurrence of the patterns in folded text.
+func (a *automaton) scanFolded(text []byte, fn func(pattern, end int) bool) {
+	var s int32
+	for i, b := range text {
+		s = a.next[s][a.class[b]]
+		for _, p := range a.out[s] {
+			if !fn(p, i+1) {
+				return
+			}
+		}
+	}
+}
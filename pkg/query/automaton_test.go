@@ -0,0 +1,208 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+func TestAutomaton(t *testing.T) {
+	tests := map[string]struct {
+		patterns []string
+		text     string
+		// want lists the occurrences as "pattern@start".
+		want []string
+	}{
+		"single": {
+			patterns: []string{"error"},
+			text:     "an error occurred",
+			want:     []string{"error@3"},
+		},
+		"case insensitive": {
+			patterns: []string{"Error"},
+			text:     "ERROR and error",
+			want:     []string{"Error@0", "Error@10"},
+		},
+		"overlapping": {
+			patterns: []string{"he", "she", "his", "hers"},
+			text:     "ushers",
+			want:     []string{"she@1", "he@2", "hers@2"},
+		},
+		"suffix of another pattern": {
+			patterns: []string{"timeout", "out"},
+			text:     "TIMEOUT",
+			want:     []string{"timeout@0", "out@4"},
+		},
+		"repeated": {
+			patterns: []string{"aa"},
+			text:     "aaaa",
+			want:     []string{"aa@0", "aa@1", "aa@2"},
+		},
+		"non-ascii bytes": {
+			patterns: []string{"größe", "ß"},
+			text:     "die Größe",
+			want:     []string{"größe@4", "ß@8"},
+		},
+		"unicode case folding": {
+			patterns: []string{"GRÖSSE", "ошибка"},
+			text:     "größe: ОШИБКА",
+			want:     []string{"ошибка@9"},
+		},
+		"offsets of folded text which changes length": {
+			// The Kelvin sign takes 3 bytes but folds to 'k'.
+			patterns: []string{"kelvin"},
+			text:     "0 \u212Aelvin",
+			want:     []string{"kelvin@2"},
+		},
+		"no match": {
+			patterns: []string{"error", "warn"},
+			text:     "all good",
+			want:     nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var patterns [][]byte
+			for _, p := range tt.patterns {
+				patterns = append(patterns, []byte(p))
+			}
+			a := newAutomaton(patterns)
+			var got []string
+			a.scan([]byte(tt.text), func(p, start, _ int) bool {
+				got = append(got, fmt.Sprintf("%s@%d", tt.patterns[p], start))
+				return true
+			})
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("scan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgram_DuplicateKeywords(t *testing.T) {
+	expr, err := Parse("(error or ERROR) and not (timeout and Timeout)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := map[string]bool{
+		"an error occurred": true,
+		"error: timeout":    false,
+		"timeout":           false,
+	}
+	for line, want := range lines {
+		if got := expr.Match(&api.Log{Content: []byte(line)}); got != want {
+			t.Errorf("Match(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
+
+// benchKeywords returns n distinct keywords, like those of a long query.
+func benchKeywords(n int) []string {
+	ret := make([]string, n)
+	for i := range ret {
+		ret[i] = fmt.Sprintf("keyword%02d", i)
+	}
+	return ret
+}
+
+var benchLine = []byte(strings.Repeat("2026-10-18T10:00:00Z INFO request served path=/api/v1/users status=200 took=12ms ", 3) + "keyword39\n")
+
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range []int{5, 40} {
+		keywords := benchKeywords(n)
+		b.Run(fmt.Sprintf("naive/%d", n), func(b *testing.B) {
+			terms := make([][]byte, n)
+			for i, k := range keywords {
+				terms[i] = []byte(k)
+			}
+			for b.Loop() {
+				// What a query of keywords joined by or did before.
+				for _, t := range terms {
+					if bytesContainsFold(benchLine, t) {
+						break
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("automaton/%d", n), func(b *testing.B) {
+			expr, err := Parse(strings.Join(keywords, " or "))
+			if err != nil {
+				b.Fatal(err)
+			}
+			l := &api.Log{Content: benchLine}
+			for b.Loop() {
+				expr.Match(l)
+			}
+		})
+	}
+}
+
+// naiveHighlight is how Highlight located keywords before the automaton,
+// given longer terms first.
+func naiveHighlight(line []byte, terms [][]byte) []byte {
+	var buf []byte
+	i := 0
+	for i < len(line) {
+		matched := false
+		for idx, term := range terms {
+			tl := len(term)
+			if i+tl <= len(line) && equalFold(line[i:i+tl], term) {
+				buf = append(buf, highlightColors[idx%len(highlightColors)]...)
+				buf = append(buf, line[i:i+tl]...)
+				buf = append(buf, highlightReset...)
+				i += tl
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			buf = append(buf, line[i])
+			i++
+		}
+	}
+	return buf
+}
+
+func BenchmarkHighlight(b *testing.B) {
+	for _, n := range []int{5, 40} {
+		keywords := benchKeywords(n)
+		b.Run(fmt.Sprintf("naive/%d", n), func(b *testing.B) {
+			terms := make([][]byte, n)
+			for i, k := range keywords {
+				terms[i] = []byte(k)
+			}
+			for b.Loop() {
+				naiveHighlight(benchLine, terms)
+			}
+		})
+		b.Run(fmt.Sprintf("automaton/%d", n), func(b *testing.B) {
+			var terms []Term
+			for _, k := range keywords {
+				terms = append(terms, newKeyword(k))
+			}
+			h := NewHighlighter(terms)
+			for b.Loop() {
+				h.Highlight(benchLine)
+			}
+		})
+	}
+}
+
+func TestNaiveHighlightAgrees(t *testing.T) {
+	keywords := benchKeywords(40)
+	var terms []Term
+	var raw [][]byte
+	for _, k := range keywords {
+		terms = append(terms, newKeyword(k))
+		raw = append(raw, []byte(k))
+	}
+	if got, want := NewHighlighter(terms).Highlight(benchLine), naiveHighlight(benchLine, raw); !bytes.Equal(got, want) {
+		t.Errorf("Highlight() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError is a syntax error in a query.
+type ParseError struct {
+	// Query is the query which failed to parse.
+	Query string
+	// Pos is the byte offset in Query where the error is located.
+	Pos int
+	// Msg describes the error.
+	Msg string
+	// Hint suggests how to fix the error, if any.
+	Hint string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Snippet renders the line of the query containing the error with a caret
+// under the position of the error, followed by the hint if any, e.g.
+//
+//	  error and
+//	            ^
+//	hint: "and" must be followed by a term
+func (e *ParseError) Snippet() string {
+	pos := min(max(e.Pos, 0), len(e.Query))
+	start := strings.LastIndexByte(e.Query[:pos], '\n') + 1
+	end := strings.IndexByte(e.Query[pos:], '\n')
+	if end < 0 {
+		end = len(e.Query)
+	} else {
+		end += pos
+	}
+	line := e.Query[start:end]
+
+	// Keep tabs so that the caret lines up with the echoed query.
+	var pad strings.Builder
+	for _, r := range e.Query[start:pos] {
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		fmt.Fprintf(&b, "line %d:\n", strings.Count(e.Query[:start], "\n")+1)
+	}
+	b.WriteString("  " + line + "\n")
+	b.WriteString("  " + pad.String() + "^")
+	if len(e.Hint) > 0 {
+		b.WriteString("\nhint: " + e.Hint)
+	}
+	return b.String()
+}
+
+// errorAt returns a ParseError located at pos. The query is filled in by Parse.
+func errorAt(pos int, hint string, format string, args ...any) *ParseError {
+	return &ParseError{Pos: pos, Msg: fmt.Sprintf(format, args...), Hint: hint}
+}
@@ -0,0 +1,164 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// Format returns expr in a normalized form which parses to the same query,
+// where every "and" and "or" is wrapped in parentheses, e.g.
+// (a or (b and c)) for a or b and c.
+func Format(expr Expr) string {
+	if s, ok := expr.(*Sequence); ok {
+		return Format(s.first) + " then " + Format(s.then) + " within " + s.within.String()
+	}
+	var b strings.Builder
+	format(&b, rootOf(expr))
+	return b.String()
+}
+
+// Explain writes expr as a tree with one subexpression per line, indented
+// under its parent and marked with + if l matches it or - otherwise. Unlike
+// Match, every subexpression is evaluated.
+//
+// The two sides of a sequence are explained separately, since whether a line
+// completes a sequence depends on the lines before it.
+func Explain(w io.Writer, expr Expr, l *api.Log) error {
+	s, ok := expr.(*Sequence)
+	if !ok {
+		return explain(w, rootOf(expr), l, 0)
+	}
+	for _, side := range []struct {
+		name string
+		root node
+	}{{"first", s.first.root}, {"then", s.then.root}} {
+		if _, err := fmt.Fprintf(w, "%s:\n", side.name); err != nil {
+			return err
+		}
+		if err := explain(w, side.root, l, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rootOf(expr Expr) node {
+	switch t := expr.(type) {
+	case *program:
+		return t.root
+	case node:
+		return t
+	}
+	panic(fmt.Sprintf("query: unexpected Expr %T", expr))
+}
+
+func explain(w io.Writer, n node, l *api.Log, depth int) error {
+	mark := "-"
+	if n.Match(l) {
+		mark = "+"
+	}
+	var b strings.Builder
+	format(&b, n)
+	if _, err := fmt.Fprintf(w, "%s%s %s\n", strings.Repeat("  ", depth), mark, b.String()); err != nil {
+		return err
+	}
+	for _, child := range children(n) {
+		if err := explain(w, child, l, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func children(n node) []node {
+	switch t := n.(type) {
+	case *andExpr:
+		return []node{t.left, t.right}
+	case *orExpr:
+		return []node{t.left, t.right}
+	case *notExpr:
+		return []node{t.expr}
+	}
+	return nil
+}
+
+func format(b *strings.Builder, n node) {
+	switch t := n.(type) {
+	case *andExpr:
+		b.WriteByte('(')
+		format(b, t.left)
+		b.WriteString(" and ")
+		format(b, t.right)
+		b.WriteByte(')')
+	case *orExpr:
+		b.WriteByte('(')
+		format(b, t.left)
+		b.WriteString(" or ")
+		format(b, t.right)
+		b.WriteByte(')')
+	case *notExpr:
+		b.WriteString("not ")
+		format(b, t.expr)
+	case *keyword:
+		term := string(t.term)
+		switch {
+		case !needsQuote(term):
+		case strings.Contains(term, `"`):
+			// Quoted keywords cannot contain quotes, but a regular
+			// expression matches the same lines.
+			term = formatRegexp(regexp.QuoteMeta(term))
+		default:
+			term = `"` + term + `"`
+		}
+		b.WriteString(term)
+	case *regexpTerm:
+		b.WriteString(formatRegexp(t.src))
+	case *fieldExpr:
+		b.WriteString(t.path + string(t.op) + quoteValue(t.value))
+	case *existsExpr:
+		b.WriteString("exists(" + t.path + ")")
+	case *metaExpr:
+		if t.re != nil {
+			b.WriteString(t.qualifier + ":" + formatRegexp(t.re.String()))
+		} else {
+			b.WriteString(t.qualifier + ":" + quoteValue(t.glob))
+		}
+	case *timeExpr:
+		qualifier := "after"
+		if t.before {
+			qualifier = "before"
+		}
+		b.WriteString(qualifier + ":" + t.t.Format(time.RFC3339Nano))
+	default:
+		fmt.Fprintf(b, "%v", n)
+	}
+}
+
+func formatRegexp(src string) string {
+	return "/" + strings.ReplaceAll(src, "/", `\/`) + "/"
+}
+
+// needsQuote reports whether the keyword s would not be lexed as a keyword
+// by itself if it were not quoted.
+func needsQuote(s string) bool {
+	switch strings.ToLower(s) {
+	case "", "and", "or", "not", "then", "within":
+		return true
+	}
+	// Quotes only start a quoted keyword at the start of a word.
+	return s[0] == '-' || s[0] == '/' || s[0] == '"' || strings.ContainsAny(s, " \t\n()=<>:")
+}
+
+// quoteValue quotes the value of a predicate if it would otherwise end the
+// token early.
+func quoteValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n()") {
+		return `"` + s + `"`
+	}
+	return s
+}
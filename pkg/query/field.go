@@ -0,0 +1,130 @@
+package query
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+type compareOp string
+
+const (
+	opEq compareOp = "="
+	opNe compareOp = "!="
+	opGt compareOp = ">"
+	opGe compareOp = ">="
+	opLt compareOp = "<"
+	opLe compareOp = "<="
+)
+
+// fieldExpr matches lines which are structured logs, i.e. JSON or logfmt, whose
+// field at path compares to value with op, e.g. level=error or status>=500.
+type fieldExpr struct {
+	path  string
+	op    compareOp
+	value string
+}
+
+func (f *fieldExpr) Match(l *api.Log) bool {
+	fs, ok := l.Fields()
+	if !ok {
+		return false
+	}
+	v, ok := fs.Lookup(f.path)
+	if !ok {
+		return false
+	}
+	return compare(v, f.op, f.value)
+}
+
+func (f *fieldExpr) eval(l *api.Log, _ []bool) bool {
+	return f.Match(l)
+}
+
+func (f *fieldExpr) Terms() []Term {
+	return nil
+}
+
+// existsExpr matches lines which are structured logs containing the field at path.
+type existsExpr struct {
+	path string
+}
+
+func (e *existsExpr) Match(l *api.Log) bool {
+	fs, ok := l.Fields()
+	if !ok {
+		return false
+	}
+	_, ok = fs.Lookup(e.path)
+	return ok
+}
+
+func (e *existsExpr) eval(l *api.Log, _ []bool) bool {
+	return e.Match(l)
+}
+
+func (e *existsExpr) Terms() []Term {
+	return nil
+}
+
+// compare reports whether v compares to want with op. Numbers and Go durations
+// like 1.2s are compared by their values, everything else by its string form.
+// Strings are compared case-insensitively like keywords, and only numbers and
+// durations are ordered.
+func compare(v any, op compareOp, want string) bool {
+	var got string
+	switch t := v.(type) {
+	case string:
+		got = t
+	case json.Number:
+		got = t.String()
+	case bool:
+		got = strconv.FormatBool(t)
+	case nil:
+		got = "null"
+	default:
+		// Objects and arrays are not comparable.
+		return false
+	}
+
+	if a, aErr := strconv.ParseFloat(got, 64); aErr == nil {
+		if b, bErr := strconv.ParseFloat(want, 64); bErr == nil {
+			return compareOrdered(a, op, b)
+		}
+	}
+	if a, aErr := time.ParseDuration(got); aErr == nil {
+		if b, bErr := time.ParseDuration(want); bErr == nil {
+			return compareOrdered(a, op, b)
+		}
+	}
+
+	switch op {
+	case opEq:
+		return strings.EqualFold(got, want)
+	case opNe:
+		return !strings.EqualFold(got, want)
+	default:
+		return false
+	}
+}
+
+func compareOrdered[T float64 | time.Duration](a T, op compareOp, b T) bool {
+	switch op {
+	case opEq:
+		return a == b
+	case opNe:
+		return a != b
+	case opGt:
+		return a > b
+	case opGe:
+		return a >= b
+	case opLt:
+		return a < b
+	case opLe:
+		return a <= b
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package query
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// foldRune maps r to the canonical rune of its simple case folding orbit, so
+// that two runes are equal under simple case folding iff they map to the same
+// rune. ASCII letters map to lower case, and so do the non-ASCII runes folding
+// to them, e.g. the Kelvin sign maps to 'k'.
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		return rune(toLowerASCII(byte(r)))
+	}
+	canonical := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		canonical = min(canonical, f)
+	}
+	if canonical < utf8.RuneSelf {
+		return rune(toLowerASCII(byte(canonical)))
+	}
+	return canonical
+}
+
+func isASCII(s []byte) bool {
+	for _, b := range s {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// fold returns s with every rune replaced by foldRune. Invalid UTF-8 is kept
+// as is.
+func fold(s []byte) []byte {
+	folded, _ := foldWithOffsets(s, false)
+	return folded
+}
+
+// foldWithOffsets is like fold. If withOffsets is true, it also returns the
+// offset in s of every byte in the folded result, plus len(s) at the end, so
+// that a span of the result can be mapped back to s.
+func foldWithOffsets(s []byte, withOffsets bool) (folded []byte, offsets []int) {
+	folded = make([]byte, 0, len(s))
+	if withOffsets {
+		offsets = make([]int, 0, len(s)+1)
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		n := len(folded)
+		if r == utf8.RuneError && size <= 1 {
+			folded = append(folded, s[i])
+			size = 1
+		} else {
+			folded = utf8.AppendRune(folded, foldRune(r))
+		}
+		if withOffsets {
+			for range len(folded) - n {
+				offsets = append(offsets, i)
+			}
+		}
+		i += size
+	}
+	if withOffsets {
+		offsets = append(offsets, len(s))
+	}
+	return folded, offsets
+}
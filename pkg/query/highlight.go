@@ -0,0 +1,88 @@
+package query
+
+import (
+	"sort"
+)
+
+var (
+	// highlightColors is the palette of the terms, which are assigned
+	// colors in order.
+	highlightColors = [][]byte{
+		[]byte("\033[1;31m"), // red
+		[]byte("\033[1;32m"), // green
+		[]byte("\033[1;33m"), // yellow
+		[]byte("\033[1;34m"), // blue
+		[]byte("\033[1;35m"), // magenta
+		[]byte("\033[1;36m"), // cyan
+		[]byte("\033[1;91m"), // bright red
+		[]byte("\033[1;92m"), // bright green
+		[]byte("\033[1;93m"), // bright yellow
+		[]byte("\033[1;94m"), // bright blue
+		[]byte("\033[1;95m"), // bright magenta
+		[]byte("\033[1;96m"), // bright cyan
+	}
+	highlightReset = []byte("\033[0m")
+)
+
+// Highlighter wraps every match of a set of terms in a line with color escape
+// sequences. Each distinct term gets its own color from a palette, in the
+// order the terms are given. Keywords are all located in a single pass by an
+// automaton.
+type Highlighter struct {
+	termSet
+}
+
+func NewHighlighter(terms []Term) *Highlighter {
+	return &Highlighter{termSet: newTermSet(terms)}
+}
+
+// color returns the color of the idx-th term.
+func (h *Highlighter) color(idx int) int {
+	return idx % len(highlightColors)
+}
+
+type span struct {
+	start, end int
+	color      int
+}
+
+// Highlight returns line with the matches of the terms highlighted. Where
+// matches overlap, the leftmost one wins, and among those starting at the
+// same offset the longest one, so that escape sequences are never nested.
+func (h *Highlighter) Highlight(line []byte) []byte {
+	var spans []span
+	if h.ac != nil {
+		h.ac.scan(line, func(pattern, start, end int) bool {
+			spans = append(spans, span{start: start, end: end, color: h.color(h.keywords[pattern])})
+			return true
+		})
+	}
+	for i, r := range h.others {
+		for _, idx := range r.findAllIndex(line) {
+			spans = append(spans, span{start: idx[0], end: idx[1], color: h.color(h.otherIndexes[i])})
+		}
+	}
+	if len(spans) == 0 {
+		return line
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var buf []byte
+	i := 0
+	for _, sp := range spans {
+		if sp.start < i {
+			continue
+		}
+		buf = append(buf, line[i:sp.start]...)
+		buf = append(buf, highlightColors[sp.color]...)
+		buf = append(buf, line[sp.start:sp.end]...)
+		buf = append(buf, highlightReset...)
+		i = sp.end
+	}
+	return append(buf, line[i:]...)
+}
@@ -0,0 +1,76 @@
+package query
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// qualifiers are the metadata of a log which can be matched by a metaExpr.
+var qualifiers = map[string]func(l *api.Log) string{
+	"pod":       func(l *api.Log) string { return l.Pod },
+	"container": func(l *api.Log) string { return l.Container },
+	"namespace": func(l *api.Log) string { return l.Namespace },
+	"node":      func(l *api.Log) string { return l.Node },
+}
+
+// metaExpr matches logs whose metadata, e.g. the container name, matches a
+// glob pattern like istio-* or a regular expression like /^web-\d+$/.
+type metaExpr struct {
+	qualifier string
+	get       func(l *api.Log) string
+
+	glob string
+	re   *regexp.Regexp
+}
+
+func newMetaExpr(qualifier, pattern string, isRegexp bool) (*metaExpr, error) {
+	m := &metaExpr{qualifier: qualifier, get: qualifiers[qualifier]}
+	if isRegexp {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in %s:/%s/: %w", qualifier, pattern, err)
+		}
+		m.re = re
+		return m, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern in %s:%s: %w", qualifier, pattern, err)
+	}
+	m.glob = pattern
+	return m, nil
+}
+
+func (m *metaExpr) Match(l *api.Log) bool {
+	v := m.get(l)
+	if m.re != nil {
+		return m.re.MatchString(v)
+	}
+	ok, _ := path.Match(m.glob, v)
+	return ok
+}
+
+func (m *metaExpr) eval(l *api.Log, _ []bool) bool {
+	return m.Match(l)
+}
+
+func (m *metaExpr) Terms() []Term {
+	return nil
+}
+
+// qualifierPrefix returns the qualifier which word starts with, e.g. "pod" for
+// pod:foo-*, or an empty string if there is none.
+func qualifierPrefix(word string) string {
+	name, _, found := strings.Cut(word, ":")
+	if !found {
+		return ""
+	}
+	name = strings.ToLower(name)
+	if _, ok := qualifiers[name]; !ok {
+		return ""
+	}
+	return name
+}
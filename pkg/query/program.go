@@ -0,0 +1,101 @@
+package query
+
+import (
+	"github.com/knight42/kt/pkg/api"
+)
+
+// program is a parsed query whose keywords are all looked up in a single pass
+// over the line by an automaton, before the expression tree is evaluated.
+type program struct {
+	root node
+	ac   *automaton
+	// size is the number of distinct keywords in the automaton.
+	size int
+}
+
+// compileQuery compiles the root of a query, which may be a sequence.
+func compileQuery(root node) Expr {
+	if t, ok := root.(*thenExpr); ok {
+		return newSequence(compile(t.first), compile(t.then), t.within)
+	}
+	return compile(root)
+}
+
+func compile(root node) *program {
+	p := &program{root: root}
+	indexes := make(map[string]int)
+	var patterns [][]byte
+	walk(root, func(n node) {
+		k, ok := n.(*keyword)
+		if !ok || len(k.term) == 0 {
+			return
+		}
+		folded := string(fold(k.term))
+		idx, ok := indexes[folded]
+		if !ok {
+			idx = len(patterns)
+			indexes[folded] = idx
+			patterns = append(patterns, k.term)
+		}
+		k.index = idx
+	})
+	if len(patterns) > 0 {
+		p.ac = newAutomaton(patterns)
+		p.size = len(patterns)
+	}
+	return p
+}
+
+// clone copies the keywords of n together with the expressions holding them,
+// since compile sets the index of keywords. A macro is cloned wherever it is
+// used, so that it can be compiled into several programs, e.g. both parts of a
+// sequence.
+func clone(n node) node {
+	switch t := n.(type) {
+	case *keyword:
+		k := *t
+		return &k
+	case *andExpr:
+		return &andExpr{left: clone(t.left), right: clone(t.right)}
+	case *orExpr:
+		return &orExpr{left: clone(t.left), right: clone(t.right)}
+	case *notExpr:
+		return &notExpr{expr: clone(t.expr)}
+	}
+	return n
+}
+
+// walk calls fn for n and all of its descendants.
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch t := n.(type) {
+	case *andExpr:
+		walk(t.left, fn)
+		walk(t.right, fn)
+	case *orExpr:
+		walk(t.left, fn)
+		walk(t.right, fn)
+	case *notExpr:
+		walk(t.expr, fn)
+	}
+}
+
+func (p *program) Match(l *api.Log) bool {
+	if p.ac == nil {
+		return p.root.eval(l, nil)
+	}
+	found := make([]bool, p.size)
+	remaining := p.size
+	p.ac.scan(l.Content, func(pattern, _, _ int) bool {
+		if !found[pattern] {
+			found[pattern] = true
+			remaining--
+		}
+		return remaining > 0
+	})
+	return p.root.eval(l, found)
+}
+
+func (p *program) Terms() []Term {
+	return p.root.Terms()
+}
@@ -1,95 +1,350 @@
 package query
 
 import (
+	"bytes"
 	"fmt"
-	"sort"
+	"regexp"
 	"strings"
+	"time"
 	"unicode"
+
+	"github.com/knight42/kt/pkg/api"
 )
 
 type Expr interface {
-	Match(line []byte) bool
-	Terms() [][]byte
+	Match(l *api.Log) bool
+	Terms() []Term
+}
+
+// Term is a single pattern of a query, either a keyword or a regular
+// expression. Terms are located within lines by Highlighter and TermMatcher.
+type Term interface {
+	String() string
+}
+
+// node is implemented by every expression of a parsed query.
+type node interface {
+	Expr
+	// eval is like Match, except that found, if not nil, reports for every
+	// keyword whether it occurs in the line, see program.
+	eval(l *api.Log, found []bool) bool
 }
 
 type keyword struct {
 	term []byte
+	// index is the index of the keyword in the found set of a program, or
+	// -1 if the keyword is not part of one.
+	index int
+}
+
+func newKeyword(term string) *keyword {
+	return &keyword{term: []byte(term), index: -1}
+}
+
+func (k *keyword) Match(l *api.Log) bool {
+	return k.eval(l, nil)
+}
+
+func (k *keyword) eval(l *api.Log, found []bool) bool {
+	if found != nil && k.index >= 0 {
+		return found[k.index]
+	}
+	return bytesContainsFold(l.Content, k.term)
+}
+
+func (k *keyword) Terms() []Term {
+	return []Term{k}
+}
+
+func (k *keyword) String() string {
+	return string(k.term)
+}
+
+type regexpTerm struct {
+	re  *regexp.Regexp
+	src string
+}
+
+func (r *regexpTerm) Match(l *api.Log) bool {
+	return r.re.Match(l.Content)
 }
 
-func (k *keyword) Match(line []byte) bool {
-	return bytesContainsFold(line, k.term)
+func (r *regexpTerm) eval(l *api.Log, _ []bool) bool {
+	return r.Match(l)
 }
 
-func (k *keyword) Terms() [][]byte {
-	return [][]byte{k.term}
+func (r *regexpTerm) Terms() []Term {
+	return []Term{r}
+}
+
+// findAllIndex returns the [start, end) offsets of all successive
+// non-overlapping matches of r in line.
+func (r *regexpTerm) findAllIndex(line []byte) [][]int {
+	spans := r.re.FindAllIndex(line, -1)
+	// Empty matches (e.g. /a*/) are useless for highlighting.
+	n := 0
+	for _, sp := range spans {
+		if sp[1] > sp[0] {
+			spans[n] = sp
+			n++
+		}
+	}
+	return spans[:n]
+}
+
+func (r *regexpTerm) String() string {
+	return "/" + r.src + "/"
 }
 
 type andExpr struct {
-	left, right Expr
+	left, right node
+}
+
+func (a *andExpr) Match(l *api.Log) bool {
+	return a.eval(l, nil)
 }
 
-func (a *andExpr) Match(line []byte) bool {
-	return a.left.Match(line) && a.right.Match(line)
+func (a *andExpr) eval(l *api.Log, found []bool) bool {
+	return a.left.eval(l, found) && a.right.eval(l, found)
 }
 
-func (a *andExpr) Terms() [][]byte {
+func (a *andExpr) Terms() []Term {
 	return append(a.left.Terms(), a.right.Terms()...)
 }
 
 type orExpr struct {
-	left, right Expr
+	left, right node
+}
+
+func (o *orExpr) Match(l *api.Log) bool {
+	return o.eval(l, nil)
 }
 
-func (o *orExpr) Match(line []byte) bool {
-	return o.left.Match(line) || o.right.Match(line)
+func (o *orExpr) eval(l *api.Log, found []bool) bool {
+	return o.left.eval(l, found) || o.right.eval(l, found)
 }
 
-func (o *orExpr) Terms() [][]byte {
+func (o *orExpr) Terms() []Term {
 	return append(o.left.Terms(), o.right.Terms()...)
 }
 
+type notExpr struct {
+	expr node
+}
+
+func (n *notExpr) Match(l *api.Log) bool {
+	return n.eval(l, nil)
+}
+
+func (n *notExpr) eval(l *api.Log, found []bool) bool {
+	return !n.expr.eval(l, found)
+}
+
+// Terms returns nothing, since a negated term never appears in a matched line
+// and thus must not be highlighted.
+func (n *notExpr) Terms() []Term {
+	return nil
+}
+
 // Parse parses a query DSL string into an Expr.
 //
 // Grammar:
 //
+//	query  = expr ("then" expr "within" DURATION)?
 //	expr   = term ("or" term)*
 //	term   = factor ("and" factor)*
-//	factor = KEYWORD | "(" expr ")"
+//	factor = ("not" | "-") factor | KEYWORD | REGEXP | FIELD | META | TIME | exists | "(" expr ")"
+//	exists = "exists" "(" KEYWORD ")"
+//
+// A KEYWORD matches case-insensitively under Unicode simple case folding, so
+// ошибка matches ОШИБКА and σ matches Σ or ς. Full folding such as ß to ss is
+// not applied.
+//
+// A REGEXP is delimited by slashes, e.g. /timeout after \d+ms/, and matches
+// case-insensitively like a KEYWORD. A slash inside it must be escaped as \/.
+//
+// A FIELD is a predicate on a structured log line, e.g. level=error,
+// status>=500 or user.id="42", where the operator is one of = != > >= < <=.
+// Numbers and durations like 1.2s are compared by value. Lines which are
+// neither JSON objects nor logfmt never match it.
+//
+// A META restricts the metadata of a log with a glob pattern or a regular
+// expression, e.g. container:istio-proxy, pod:web-* or node:/^gpu-\d+$/.
+// The qualifier is one of pod, container, namespace or node.
+//
+// A TIME restricts the timestamp of a log, e.g.
+// after:"2026-10-18T10:00Z" and before:"2026-10-18T10:05Z" selects the logs
+// from 10:00 inclusive to 10:05 exclusive. Times without a zone, like
+// "2026-10-18 10:00", are in local time.
+//
+// A query of the form A then B within D, e.g.
+// "connection reset" then panic within 10s, is parsed into a *Sequence. It
+// matches a line matching B logged at most D after a line matching A by the
+// same container. The words then and within must be quoted to be matched as
+// keywords.
+//
+// Syntax errors are returned as *ParseError.
 func Parse(input string) (Expr, error) {
-	tokens, err := lex(input)
+	expr, err := parse(input)
+	if err != nil {
+		err.Query = input
+		return nil, err
+	}
+	return compileQuery(expr), nil
+}
+
+// ParseFile parses the content of a query file into an Expr. Besides the
+// syntax of Parse, a query file may contain comments starting with # and
+// macros, which are defined before the query with
+//
+//	let NAME = expr
+//
+// and referenced as $NAME in the query or in later macros. Line breaks are
+// insignificant, so both macros and the query may span multiple lines.
+//
+// Syntax errors are returned as *ParseError.
+func ParseFile(content string) (Expr, error) {
+	expr, err := parseFile(content)
+	if err != nil {
+		err.Query = content
+		return nil, err
+	}
+	return compileQuery(expr), nil
+}
+
+func parse(input string) (node, *ParseError) {
+	tokens, err := lex(input, false)
 	if err != nil {
 		return nil, err
 	}
 	if len(tokens) == 0 {
-		return nil, fmt.Errorf("empty query")
+		return nil, errorAt(0, "", "empty query")
+	}
+	p := &parser{tokens: tokens, end: len(input)}
+	return p.parseQuery()
+}
+
+func parseFile(content string) (node, *ParseError) {
+	tokens, err := lex(content, true)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, end: len(content), macros: make(map[string]node)}
+	for t := p.peek(); t != nil && t.kind == tokenLet; t = p.peek() {
+		p.next()
+		if _, ok := p.macros[t.value]; ok {
+			return nil, errorAt(t.pos, "", "macro $%s is already defined", t.value)
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		p.macros[t.value] = expr
 	}
-	p := &parser{tokens: tokens}
+	if p.peek() == nil {
+		return nil, errorAt(len(content), "", "missing query after the macros")
+	}
+	return p.parseQuery()
+}
+
+// parseQuery parses the remaining tokens as a single expression.
+func (p *parser) parseQuery() (node, *ParseError) {
 	expr, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
-	if p.pos < len(p.tokens) {
-		return nil, fmt.Errorf("unexpected token: %q", p.tokens[p.pos].value)
+	if t := p.peek(); t != nil && t.kind == tokenThen {
+		p.next()
+		expr, err = p.parseSequence(expr, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t != nil {
+		if t.kind == tokenLet {
+			return nil, errorAt(t.pos, "macros must be defined before the query", "unexpected macro definition")
+		}
+		if t.kind == tokenRParen {
+			return nil, errorAt(t.pos, `remove it or add a matching "("`, "unbalanced parenthesis")
+		}
+		return nil, errorAt(t.pos, `join terms with "and" or "or", or quote them to match a phrase`, "unexpected %q", t.value)
 	}
 	return expr, nil
 }
 
+// parseSequence parses the rest of first then B within D, where then is the
+// token of "then".
+func (p *parser) parseSequence(first node, then *token) (node, *ParseError) {
+	second, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	const hint = `add a time window like "within 10s"`
+	t := p.next()
+	if t == nil {
+		return nil, errorAt(p.end, hint, "missing time window of %q", then.value)
+	}
+	if t.kind != tokenWithin {
+		if t.kind == tokenThen {
+			return nil, errorAt(t.pos, "", "only two expressions may be joined with %q", t.value)
+		}
+		return nil, errorAt(t.pos, hint, "unexpected %q", t.value)
+	}
+	d := p.next()
+	if d == nil {
+		return nil, errorAt(p.end, hint, "missing duration after %q", t.value)
+	}
+	within, perr := time.ParseDuration(d.value)
+	if d.kind != tokenKeyword || perr != nil || within <= 0 {
+		return nil, errorAt(d.pos, hint, "invalid duration %q", d.value)
+	}
+	return &thenExpr{first: first, then: second, within: within}, nil
+}
+
 type tokenKind int
 
 const (
 	tokenKeyword tokenKind = iota
+	tokenRegexp
+	tokenField
+	tokenExists
+	tokenMeta
+	tokenTime
 	tokenAnd
 	tokenOr
+	tokenNot
+	tokenThen
+	tokenWithin
 	tokenLParen
 	tokenRParen
+	// tokenLet and tokenMacro only occur in query files, where value is
+	// the name of the macro.
+	tokenLet
+	tokenMacro
 )
 
 type token struct {
 	kind  tokenKind
 	value string
+	// pos is the byte offset of the token in the query.
+	pos int
+
+	// field, op and operand are set for tokenField, field, operand and
+	// regexp for tokenMeta, field and operand for tokenTime.
+	field   string
+	op      compareOp
+	operand string
+	regexp  bool
 }
 
-func lex(input string) ([]token, error) {
+const unterminatedQuoteHint = `add the closing '"'`
+
+// macroPattern matches the start of a macro definition in a query file.
+var macroPattern = regexp.MustCompile(`^let\s+([A-Za-z_]\w*)\s*=`)
+
+// lex splits input into tokens. If file is true, input is the content of a
+// query file, which may contain comments and macros.
+func lex(input string, file bool) ([]token, *ParseError) {
 	var tokens []token
 	i := 0
 	for i < len(input) {
@@ -98,45 +353,183 @@ func lex(input string) ([]token, error) {
 			i++
 			continue
 		}
+		// The duration after within is a single word, even if it looks
+		// like a negation, so that -1s is reported as a whole.
+		if n := len(tokens); n > 0 && tokens[n-1].kind == tokenWithin && ch != '(' && ch != ')' {
+			start := i
+			for i < len(input) && !unicode.IsSpace(rune(input[i])) && input[i] != '(' && input[i] != ')' {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenKeyword, value: input[start:i], pos: start})
+			continue
+		}
+		if file {
+			switch {
+			case ch == '#':
+				for i < len(input) && input[i] != '\n' {
+					i++
+				}
+				continue
+			case ch == '$':
+				start := i
+				i++
+				for i < len(input) && isWordByte(input[i]) {
+					i++
+				}
+				if i == start+1 {
+					return nil, errorAt(start, "", "missing macro name after $")
+				}
+				tokens = append(tokens, token{kind: tokenMacro, value: input[start+1 : i], pos: start})
+				continue
+			case strings.HasPrefix(input[i:], "let") && (i+3 == len(input) || unicode.IsSpace(rune(input[i+3]))):
+				m := macroPattern.FindStringSubmatch(input[i:])
+				if m == nil {
+					return nil, errorAt(i, "define a macro with: let NAME = expr", "invalid macro definition")
+				}
+				tokens = append(tokens, token{kind: tokenLet, value: m[1], pos: i})
+				i += len(m[0])
+				continue
+			}
+		}
 		if ch == '(' {
-			tokens = append(tokens, token{kind: tokenLParen, value: "("})
+			tokens = append(tokens, token{kind: tokenLParen, value: "(", pos: i})
 			i++
 			continue
 		}
 		if ch == ')' {
-			tokens = append(tokens, token{kind: tokenRParen, value: ")"})
+			tokens = append(tokens, token{kind: tokenRParen, value: ")", pos: i})
 			i++
 			continue
 		}
 		if ch == '"' {
 			end := strings.IndexByte(input[i+1:], '"')
 			if end < 0 {
-				return nil, fmt.Errorf("unterminated quoted string starting at position %d", i)
+				return nil, errorAt(i, unterminatedQuoteHint, "unterminated quoted string")
 			}
-			tokens = append(tokens, token{kind: tokenKeyword, value: input[i+1 : i+1+end]})
+			tokens = append(tokens, token{kind: tokenKeyword, value: input[i+1 : i+1+end], pos: i})
 			i += end + 2
 			continue
 		}
+		if ch == '/' {
+			if src, end, ok := scanRegexp(input, i); ok {
+				tokens = append(tokens, token{kind: tokenRegexp, value: src, pos: i})
+				i = end
+				continue
+			}
+		}
+		if ch == '-' && i+1 < len(input) && !unicode.IsSpace(rune(input[i+1])) && input[i+1] != ')' {
+			tokens = append(tokens, token{kind: tokenNot, value: "-", pos: i})
+			i++
+			continue
+		}
+		if q := qualifierPrefix(input[i:]); len(q) > 0 {
+			valueStart := i + len(q) + 1
+			if valueStart < len(input) && input[valueStart] == '/' {
+				if src, end, ok := scanRegexp(input, valueStart); ok {
+					tokens = append(tokens, token{kind: tokenMeta, value: input[i:end], pos: i, field: q, operand: src, regexp: true})
+					i = end
+					continue
+				}
+			}
+		}
 		start := i
 		for i < len(input) && !unicode.IsSpace(rune(input[i])) && input[i] != '(' && input[i] != ')' {
+			// The value of a predicate may be quoted, e.g. msg="not found".
+			if input[i] == '"' && i > start && strings.IndexByte("=<>:", input[i-1]) >= 0 {
+				end := strings.IndexByte(input[i+1:], '"')
+				if end < 0 {
+					return nil, errorAt(i, unterminatedQuoteHint, "unterminated quoted string")
+				}
+				i += end + 2
+				continue
+			}
 			i++
 		}
 		word := input[start:i]
+		t := token{kind: tokenKeyword, value: word, pos: start}
 		switch strings.ToLower(word) {
 		case "and":
-			tokens = append(tokens, token{kind: tokenAnd, value: word})
+			t.kind = tokenAnd
 		case "or":
-			tokens = append(tokens, token{kind: tokenOr, value: word})
+			t.kind = tokenOr
+		case "not":
+			t.kind = tokenNot
+		case "then":
+			t.kind = tokenThen
+		case "within":
+			t.kind = tokenWithin
+		case "exists":
+			if i < len(input) && input[i] == '(' {
+				t.kind = tokenExists
+			}
 		default:
-			tokens = append(tokens, token{kind: tokenKeyword, value: word})
+			if q := qualifierPrefix(word); len(q) > 0 && len(word) > len(q)+1 {
+				t.kind, t.field, t.operand = tokenMeta, q, unquote(word[len(q)+1:])
+			} else if q := timePrefix(word); len(q) > 0 && len(word) > len(q)+1 {
+				t.kind, t.field, t.operand = tokenTime, q, unquote(word[len(q)+1:])
+			} else if m := fieldPattern.FindStringSubmatch(word); m != nil {
+				t.kind, t.field, t.op, t.operand = tokenField, m[1], compareOp(m[2]), unquote(m[3])
+			}
 		}
+		tokens = append(tokens, t)
 	}
 	return tokens, nil
 }
 
+func isWordByte(b byte) bool {
+	return b == '_' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// fieldPattern matches a field predicate like level=error, status>=500 or
+// user.id="42".
+var fieldPattern = regexp.MustCompile(`^([A-Za-z_@][\w.@-]*)(!=|>=|<=|=|>|<)(.+)$`)
+
+// scanRegexp scans a slash-delimited regular expression starting at input[start].
+// It returns the unescaped expression and the offset right after the closing
+// slash. ok is false if input[start:] is not a regular expression literal, e.g.
+// a path like /healthz, in which case it should be treated as a keyword.
+func scanRegexp(input string, start int) (src string, end int, ok bool) {
+	var b strings.Builder
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 < len(input) && input[i+1] == '/' {
+				b.WriteByte('/')
+				i++
+				continue
+			}
+			b.WriteByte('\\')
+			if i+1 < len(input) {
+				b.WriteByte(input[i+1])
+				i++
+			}
+		case '/':
+			end = i + 1
+			if end < len(input) && !unicode.IsSpace(rune(input[end])) && input[end] != '(' && input[end] != ')' {
+				return "", 0, false
+			}
+			return b.String(), end, true
+		default:
+			b.WriteByte(input[i])
+		}
+	}
+	return "", 0, false
+}
+
 type parser struct {
 	tokens []token
 	pos    int
+	// end is the length of the query, where an unexpected end is reported.
+	end int
+	// macros holds the macros defined so far in a query file.
+	macros map[string]node
 }
 
 func (p *parser) peek() *token {
@@ -154,7 +547,22 @@ func (p *parser) next() *token {
 	return t
 }
 
-func (p *parser) parseExpr() (Expr, error) {
+func isOperator(t *token) bool {
+	return t.kind == tokenAnd || t.kind == tokenOr || t.kind == tokenNot || t.kind == tokenThen
+}
+
+// unexpectedEnd reports that the query ends where a term was expected.
+func (p *parser) unexpectedEnd() *ParseError {
+	hint := ""
+	if p.pos > 0 {
+		if last := &p.tokens[p.pos-1]; isOperator(last) {
+			hint = fmt.Sprintf("%q must be followed by a term", last.value)
+		}
+	}
+	return errorAt(p.end, hint, "unexpected end of query")
+}
+
+func (p *parser) parseExpr() (node, *ParseError) {
 	left, err := p.parseTerm()
 	if err != nil {
 		return nil, err
@@ -170,7 +578,7 @@ func (p *parser) parseExpr() (Expr, error) {
 	return left, nil
 }
 
-func (p *parser) parseTerm() (Expr, error) {
+func (p *parser) parseTerm() (node, *ParseError) {
 	left, err := p.parseFactor()
 	if err != nil {
 		return nil, err
@@ -186,30 +594,87 @@ func (p *parser) parseTerm() (Expr, error) {
 	return left, nil
 }
 
-func (p *parser) parseFactor() (Expr, error) {
+func (p *parser) parseFactor() (node, *ParseError) {
 	t := p.next()
 	if t == nil {
-		return nil, fmt.Errorf("unexpected end of query")
+		return nil, p.unexpectedEnd()
 	}
 	switch t.kind {
 	case tokenKeyword:
-		return &keyword{term: []byte(t.value)}, nil
+		return newKeyword(t.value), nil
+	case tokenRegexp:
+		re, err := regexp.Compile("(?i)" + t.value)
+		if err != nil {
+			return nil, errorAt(t.pos, "", "invalid regular expression /%s/: %v", t.value, err)
+		}
+		return &regexpTerm{re: re, src: t.value}, nil
+	case tokenField:
+		return &fieldExpr{path: t.field, op: t.op, value: t.operand}, nil
+	case tokenMeta:
+		m, err := newMetaExpr(t.field, t.operand, t.regexp)
+		if err != nil {
+			return nil, errorAt(t.pos, "", "%v", err)
+		}
+		return m, nil
+	case tokenTime:
+		ts, ok := parseTime(t.operand)
+		if !ok {
+			return nil, errorAt(t.pos, "use RFC 3339 like 2026-10-18T10:00:00Z, or 2026-10-18 10:00 in local time", "invalid time %q in %s:", t.operand, t.field)
+		}
+		return &timeExpr{before: t.field == "before", t: ts}, nil
+	case tokenExists:
+		p.next() // the lexer guarantees an opening parenthesis
+		name := p.next()
+		if name == nil {
+			return nil, p.unexpectedEnd()
+		}
+		if name.kind != tokenKeyword {
+			return nil, errorAt(name.pos, "", "expected field name in %s()", t.value)
+		}
+		if rp := p.next(); rp == nil || rp.kind != tokenRParen {
+			return nil, errorAt(t.pos, `add a closing ")"`, "unclosed parenthesis of %s()", t.value)
+		}
+		return &existsExpr{path: name.value}, nil
+	case tokenNot:
+		expr, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr: expr}, nil
 	case tokenLParen:
 		expr, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
 		closing := p.next()
-		if closing == nil || closing.kind != tokenRParen {
-			return nil, fmt.Errorf("expected closing parenthesis")
+		if closing == nil {
+			return nil, errorAt(t.pos, `add a closing ")"`, "unclosed parenthesis")
+		}
+		if closing.kind != tokenRParen {
+			return nil, errorAt(closing.pos, `join terms with "and" or "or", or quote them to match a phrase`, "unexpected %q", closing.value)
 		}
 		return expr, nil
+	case tokenRParen:
+		return nil, errorAt(t.pos, `remove it or add a matching "("`, "unbalanced parenthesis")
+	case tokenMacro:
+		expr, ok := p.macros[t.value]
+		if !ok {
+			return nil, errorAt(t.pos, fmt.Sprintf("define it before use with: let %s = expr", t.value), "undefined macro $%s", t.value)
+		}
+		return clone(expr), nil
+	case tokenLet:
+		return nil, errorAt(t.pos, "macros must be defined before the query", "unexpected macro definition")
 	default:
-		return nil, fmt.Errorf("unexpected token: %q", t.value)
+		return nil, errorAt(t.pos, fmt.Sprintf("%q needs a term on both sides", t.value), "unexpected %q", t.value)
 	}
 }
 
+// bytesContainsFold reports whether needle is within haystack under simple
+// case folding.
 func bytesContainsFold(haystack, needle []byte) bool {
+	if !isASCII(needle) || !isASCII(haystack) {
+		return bytes.Contains(fold(haystack), fold(needle))
+	}
 	nl := len(needle)
 	hl := len(haystack)
 	if nl > hl {
@@ -223,59 +688,13 @@ func bytesContainsFold(haystack, needle []byte) bool {
 	return false
 }
 
+// equalFold reports whether a and b, which must be of the same length, are
+// equal ignoring ASCII case.
 func equalFold(a, b []byte) bool {
 	for i := range a {
-		ca, cb := a[i], b[i]
-		if ca == cb {
-			continue
-		}
-		if 'A' <= ca && ca <= 'Z' {
-			ca += 'a' - 'A'
-		}
-		if 'A' <= cb && cb <= 'Z' {
-			cb += 'a' - 'A'
-		}
-		if ca != cb {
+		if toLowerASCII(a[i]) != toLowerASCII(b[i]) {
 			return false
 		}
 	}
 	return true
 }
-
-var (
-	highlightStart = []byte("\033[1;31m")
-	highlightReset = []byte("\033[0m")
-)
-
-func Highlight(line []byte, terms [][]byte) []byte {
-	if len(terms) == 0 {
-		return line
-	}
-	sorted := make([][]byte, len(terms))
-	copy(sorted, terms)
-	sort.Slice(sorted, func(i, j int) bool {
-		return len(sorted[i]) > len(sorted[j])
-	})
-
-	var buf []byte
-	i := 0
-	for i < len(line) {
-		matched := false
-		for _, term := range sorted {
-			tl := len(term)
-			if i+tl <= len(line) && equalFold(line[i:i+tl], term) {
-				buf = append(buf, highlightStart...)
-				buf = append(buf, line[i:i+tl]...)
-				buf = append(buf, highlightReset...)
-				i += tl
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			buf = append(buf, line[i])
-			i++
-		}
-	}
-	return buf
-}
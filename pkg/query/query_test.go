@@ -2,14 +2,20 @@ package query
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
 )
 
 func TestParse_Match(t *testing.T) {
 	tests := map[string]struct {
-		query   string
-		line    string
-		want    bool
+		query string
+		line  string
+		want  bool
 	}{
 		"single keyword match": {
 			query: "error",
@@ -101,6 +107,251 @@ func TestParse_Match(t *testing.T) {
 			line:  "something else",
 			want:  false,
 		},
+		"not excludes keyword": {
+			query: "error and not healthcheck",
+			line:  "error in healthcheck",
+			want:  false,
+		},
+		"not keeps other lines": {
+			query: "error and not healthcheck",
+			line:  "error in handler",
+			want:  true,
+		},
+		"dash negation": {
+			query: "error and -healthcheck",
+			line:  "error in healthcheck",
+			want:  false,
+		},
+		"not binds tighter than and": {
+			query: "not a and b",
+			line:  "b",
+			want:  true,
+		},
+		"not binds tighter than or": {
+			query: "not a or b",
+			line:  "a b",
+			want:  true,
+		},
+		"not parenthesized group": {
+			query: "not (a or b)",
+			line:  "a",
+			want:  false,
+		},
+		"dash parenthesized group": {
+			query: "-(a or b) and c",
+			line:  "c",
+			want:  true,
+		},
+		"double negation": {
+			query: "not not error",
+			line:  "an error occurred",
+			want:  true,
+		},
+		"dash inside keyword is literal": {
+			query: "foo-bar",
+			line:  "foo-bar baz",
+			want:  true,
+		},
+		"lone dash is a keyword": {
+			query: "a and -",
+			line:  "a - b",
+			want:  true,
+		},
+		"quoted not is a keyword": {
+			query: `"not"`,
+			line:  "not found",
+			want:  true,
+		},
+		"regexp match": {
+			query: `/timeout after \d+ms/`,
+			line:  "request timeout after 300ms",
+			want:  true,
+		},
+		"regexp no match": {
+			query: `/timeout after \d+ms/`,
+			line:  "request timeout after a while",
+			want:  false,
+		},
+		"regexp is case insensitive": {
+			query: `/^error:/`,
+			line:  "ERROR: boom",
+			want:  true,
+		},
+		"regexp with and": {
+			query: `/status=5\d\d/ and not healthz`,
+			line:  "GET /api status=503",
+			want:  true,
+		},
+		"regexp with or": {
+			query: `panic or /status=5\d\d/`,
+			line:  "GET /api status=404",
+			want:  false,
+		},
+		"regexp with escaped slash": {
+			query: `/GET \/api\/v\d/`,
+			line:  "GET /api/v2/users",
+			want:  true,
+		},
+		"regexp in parens": {
+			query: `(/a+b/)`,
+			line:  "xaaab",
+			want:  true,
+		},
+		"path is a keyword": {
+			query: "/healthz",
+			line:  "GET /healthz 200",
+			want:  true,
+		},
+		"path with slashes is a keyword": {
+			query: "/api/v1 and GET",
+			line:  "GET /api/v1/users",
+			want:  true,
+		},
+		"field equals": {
+			query: "level=error",
+			line:  `{"level":"error","msg":"boom"}`,
+			want:  true,
+		},
+		"field equals ignores spacing": {
+			query: "level=error",
+			line:  `{ "level" : "ERROR", "msg": "boom" }`,
+			want:  true,
+		},
+		"field not equals": {
+			query: "level!=debug",
+			line:  `{"level":"info"}`,
+			want:  true,
+		},
+		"field missing never matches": {
+			query: "level!=debug",
+			line:  `{"msg":"boom"}`,
+			want:  false,
+		},
+		"field numeric greater or equal": {
+			query: "status>=500",
+			line:  `{"status":503}`,
+			want:  true,
+		},
+		"field numeric comparison is not lexical": {
+			query: "status>=500",
+			line:  `{"status":60}`,
+			want:  false,
+		},
+		"field numeric less than": {
+			query: "latency<0.5",
+			line:  `{"latency":0.25}`,
+			want:  true,
+		},
+		"field numeric equals string number": {
+			query: "status=500",
+			line:  `{"status":"500.0"}`,
+			want:  true,
+		},
+		"field ordering on strings never matches": {
+			query: "level>error",
+			line:  `{"level":"fatal"}`,
+			want:  false,
+		},
+		"field nested quoted value": {
+			query: `user.id="42"`,
+			line:  `{"user":{"id":42}}`,
+			want:  true,
+		},
+		"field quoted value with spaces": {
+			query: `msg="not found" and level=warn`,
+			line:  `{"level":"warn","msg":"not found"}`,
+			want:  true,
+		},
+		"field boolean": {
+			query: "cached=true",
+			line:  `{"cached":true}`,
+			want:  true,
+		},
+		"field on unstructured line": {
+			query: "level=error",
+			line:  "error: connection refused",
+			want:  false,
+		},
+		"logfmt field equals": {
+			query: "level=warn",
+			line:  "ts=2026-10-18T10:00:00Z level=warn msg=\"slow request\"",
+			want:  true,
+		},
+		"logfmt quoted field value": {
+			query: `msg="slow request"`,
+			line:  "level=warn msg=\"slow request\"",
+			want:  true,
+		},
+		"logfmt numeric comparison": {
+			query: "status>=500 and duration>1s",
+			line:  "level=warn duration=1.2s status=503",
+			want:  true,
+		},
+		"logfmt duration comparison is not lexical": {
+			query: "duration>1s",
+			line:  "level=warn duration=900ms status=503",
+			want:  false,
+		},
+		"logfmt duration with mixed units": {
+			query: "duration<=1m30s",
+			line:  "duration=90s",
+			want:  true,
+		},
+		"duration against non duration": {
+			query: "duration>1s",
+			line:  "duration=slow",
+			want:  false,
+		},
+		"logfmt in semi-structured line": {
+			query: "status>=500",
+			line:  "GET /api/v1/users status=503",
+			want:  true,
+		},
+		"field composed with keyword": {
+			query: "level=error and -healthz",
+			line:  `{"level":"error","path":"/healthz"}`,
+			want:  false,
+		},
+		"field or keyword": {
+			query: "status>=500 or panic",
+			line:  "panic: runtime error",
+			want:  true,
+		},
+		"exists": {
+			query: "exists(trace_id)",
+			line:  `{"trace_id":"abc"}`,
+			want:  true,
+		},
+		"exists nested": {
+			query: "exists(user.id) and not exists(error)",
+			line:  `{"user":{"id":1}}`,
+			want:  true,
+		},
+		"exists missing": {
+			query: "exists(trace_id)",
+			line:  `{"span_id":"abc"}`,
+			want:  false,
+		},
+		"exists with null value": {
+			query: "exists(trace_id)",
+			line:  `{"trace_id":null}`,
+			want:  true,
+		},
+		"exists without parens is a keyword": {
+			query: "exists",
+			line:  "file exists",
+			want:  true,
+		},
+		"quoted field predicate is a keyword": {
+			query: `"level=error"`,
+			line:  `{"msg":"level=error"}`,
+			want:  true,
+		},
+		"quoted dash term is a keyword": {
+			query: `"-v"`,
+			line:  "run -v",
+			want:  true,
+		},
 	}
 
 	for name, tt := range tests {
@@ -109,7 +360,7 @@ func TestParse_Match(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Parse(%q) error: %v", tt.query, err)
 			}
-			got := expr.Match([]byte(tt.line))
+			got := expr.Match(&api.Log{Content: []byte(tt.line)})
 			if got != tt.want {
 				t.Errorf("Match(%q) = %v, want %v", tt.line, got, tt.want)
 			}
@@ -117,12 +368,123 @@ func TestParse_Match(t *testing.T) {
 	}
 }
 
+func TestParse_MatchMetadata(t *testing.T) {
+	log := &api.Log{
+		Namespace: "prod",
+		Pod:       "web-7d9f8c6b5-x2x4z",
+		Container: "istio-proxy",
+		Node:      "gpu-12",
+		Content:   []byte("GET /api 503"),
+	}
+	tests := map[string]struct {
+		query string
+		want  bool
+	}{
+		"container exact":               {query: "container:istio-proxy and 503", want: true},
+		"container mismatch":            {query: "container:app and 503", want: false},
+		"container glob":                {query: "container:istio-*", want: true},
+		"pod glob":                      {query: "pod:web-*", want: true},
+		"pod glob mismatch":             {query: "pod:api-*", want: false},
+		"pod regexp":                    {query: `pod:/^web-[a-z0-9]+-\w{5}$/`, want: true},
+		"node regexp":                   {query: `node:/^gpu-\d+$/`, want: true},
+		"namespace":                     {query: "namespace:prod", want: true},
+		"quoted value":                  {query: `namespace:"prod"`, want: true},
+		"qualifier is case insensitive": {query: "Container:istio-proxy", want: true},
+		"negated":                       {query: "503 and not container:istio-proxy", want: false},
+		"per container conditions": {
+			query: "(container:istio-proxy and 503) or (container:app and error)",
+			want:  true,
+		},
+		"regexp with parens":       {query: `container:/^(istio|envoy)-proxy$/ and 503`, want: true},
+		"empty value is a keyword": {query: "pod:", want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.query, err)
+			}
+			if got := expr.Match(log); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_MatchTime(t *testing.T) {
+	at := func(s string) *api.Log {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &api.Log{Content: []byte("error: connection refused"), Timestamp: ts}
+	}
+	tests := map[string]struct {
+		query string
+		log   *api.Log
+		want  bool
+	}{
+		"after":                         {query: `after:"2026-10-18T10:00Z"`, log: at("2026-10-18T10:00:01Z"), want: true},
+		"after is inclusive":            {query: `after:"2026-10-18T10:00Z"`, log: at("2026-10-18T10:00:00Z"), want: true},
+		"not after":                     {query: `after:"2026-10-18T10:00Z"`, log: at("2026-10-18T09:59:59.999Z"), want: false},
+		"before is exclusive":           {query: `before:"2026-10-18T10:05Z"`, log: at("2026-10-18T10:05:00Z"), want: false},
+		"before":                        {query: `before:2026-10-18T10:05:00Z`, log: at("2026-10-18T10:04:59.5Z"), want: true},
+		"other zone":                    {query: `after:2026-10-18T12:00+02:00`, log: at("2026-10-18T10:00:00Z"), want: true},
+		"nanoseconds":                   {query: `after:2026-10-18T10:00:00.000000002Z`, log: at("2026-10-18T10:00:00.000000001Z"), want: false},
+		"qualifier is case insensitive": {query: `After:2026-10-18`, log: at("2026-10-19T00:00:00Z"), want: true},
+		"window and keyword": {
+			query: `after:"2026-10-18T10:00Z" and before:"2026-10-18T10:05Z" and error`,
+			log:   at("2026-10-18T10:03:00Z"),
+			want:  true,
+		},
+		"outside window": {
+			query: `after:"2026-10-18T10:00Z" and before:"2026-10-18T10:05Z" and error`,
+			log:   at("2026-10-18T10:06:00Z"),
+			want:  false,
+		},
+		"negated":                    {query: `-before:"2026-10-18T10:00Z"`, log: at("2026-10-18T10:00:00Z"), want: true},
+		"no timestamp never matches": {query: `after:2000-01-01 or before:2100-01-01`, log: &api.Log{Content: []byte("error")}, want: false},
+		"empty value is a keyword":   {query: `after:`, log: &api.Log{Content: []byte("retry after: 5s")}, want: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.query, err)
+			}
+			if got := expr.Match(tt.log); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime_Local(t *testing.T) {
+	for _, s := range []string{"2026-10-18 10:05", "2026-10-18T10:05", "2026-10-18 10:05:00", "2026-10-18T10:05:00.000"} {
+		got, ok := parseTime(s)
+		want := time.Date(2026, 10, 18, 10, 5, 0, 0, time.Local)
+		if !ok || !got.Equal(want) {
+			t.Errorf("parseTime(%q) = %v, %v, want %v", s, got, ok, want)
+		}
+	}
+}
+
 func TestParse_Errors(t *testing.T) {
 	tests := map[string]string{
-		"empty":                "",
-		"unterminated quote":   `"hello`,
+		"empty":                 "",
+		"unterminated quote":    `"hello`,
 		"missing closing paren": "(a or b",
-		"unexpected token":     "and",
+		"unexpected token":      "and",
+		"dangling not":          "error and not",
+		"dash before paren":     "-)",
+		"invalid regexp":        "/(abc/",
+		"exists without field":  "exists()",
+		"exists unclosed":       "exists(a",
+		"unterminated value":    `msg="not found`,
+		"invalid glob":          "pod:web-[",
+		"invalid meta regexp":   "node:/(gpu/",
+		"invalid time":          "after:yesterday",
+		"time without seconds":  `before:"10:05"`,
 	}
 
 	for name, input := range tests {
@@ -135,6 +497,266 @@ func TestParse_Errors(t *testing.T) {
 	}
 }
 
+func TestParse_ErrorPositions(t *testing.T) {
+	tests := map[string]struct {
+		query    string
+		wantPos  int
+		wantMsg  string
+		wantHint string
+	}{
+		"empty": {
+			query:   "   ",
+			wantPos: 0,
+			wantMsg: "empty query",
+		},
+		"dangling and": {
+			query:    "error and",
+			wantPos:  9,
+			wantMsg:  "unexpected end of query",
+			wantHint: `"and" must be followed by a term`,
+		},
+		"dangling not": {
+			query:    "error and not ",
+			wantPos:  14,
+			wantMsg:  "unexpected end of query",
+			wantHint: `"not" must be followed by a term`,
+		},
+		"leading operator": {
+			query:    "or error",
+			wantPos:  0,
+			wantMsg:  `unexpected "or"`,
+			wantHint: `"or" needs a term on both sides`,
+		},
+		"double operator": {
+			query:    "error and or warn",
+			wantPos:  10,
+			wantMsg:  `unexpected "or"`,
+			wantHint: `"or" needs a term on both sides`,
+		},
+		"unclosed parenthesis": {
+			query:    "error and (a or b",
+			wantPos:  10,
+			wantMsg:  "unclosed parenthesis",
+			wantHint: `add a closing ")"`,
+		},
+		"extra closing parenthesis": {
+			query:    "(a or b))",
+			wantPos:  8,
+			wantMsg:  "unbalanced parenthesis",
+			wantHint: `remove it or add a matching "("`,
+		},
+		"unterminated quote": {
+			query:    `error and "connection reset`,
+			wantPos:  10,
+			wantMsg:  "unterminated quoted string",
+			wantHint: `add the closing '"'`,
+		},
+		"unterminated quoted value": {
+			query:    `msg="not found`,
+			wantPos:  4,
+			wantMsg:  "unterminated quoted string",
+			wantHint: `add the closing '"'`,
+		},
+		"missing operator": {
+			query:    "connection reset",
+			wantPos:  11,
+			wantMsg:  `unexpected "reset"`,
+			wantHint: "quote them to match a phrase",
+		},
+		"missing operator in parentheses": {
+			query:    "(a b)",
+			wantPos:  3,
+			wantMsg:  `unexpected "b"`,
+			wantHint: `join terms with "and" or "or"`,
+		},
+		"invalid regexp": {
+			query:   "error and /(abc/",
+			wantPos: 10,
+			wantMsg: "invalid regular expression /(abc/",
+		},
+		"invalid glob": {
+			query:   "a and pod:web-[",
+			wantPos: 6,
+			wantMsg: "invalid pattern in pod:web-[",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse(%q) error = %v, want a *ParseError", tt.query, err)
+			}
+			if pe.Query != tt.query {
+				t.Errorf("Query = %q, want %q", pe.Query, tt.query)
+			}
+			if pe.Pos != tt.wantPos {
+				t.Errorf("Pos = %d, want %d", pe.Pos, tt.wantPos)
+			}
+			if !strings.Contains(pe.Msg, tt.wantMsg) {
+				t.Errorf("Msg = %q, want it to contain %q", pe.Msg, tt.wantMsg)
+			}
+			if !strings.Contains(pe.Hint, tt.wantHint) || (len(tt.wantHint) == 0 && len(pe.Hint) > 0) {
+				t.Errorf("Hint = %q, want %q", pe.Hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestParseError_Snippet(t *testing.T) {
+	tests := map[string]struct {
+		err  *ParseError
+		want string
+	}{
+		"caret under position": {
+			err:  &ParseError{Query: "error and", Pos: 9, Hint: `"and" must be followed by a term`},
+			want: "  error and\n           ^\nhint: \"and\" must be followed by a term",
+		},
+		"without hint": {
+			err:  &ParseError{Query: "(a b)", Pos: 3},
+			want: "  (a b)\n     ^",
+		},
+		"multibyte characters": {
+			err:  &ParseError{Query: "größe and", Pos: 11},
+			want: "  größe and\n           ^",
+		},
+		"tabs are kept": {
+			err:  &ParseError{Query: "\terror and", Pos: 10},
+			want: "  \terror and\n  \t         ^",
+		},
+		"multiple lines": {
+			err:  &ParseError{Query: "error\nand (a", Pos: 10},
+			want: "line 2:\n  and (a\n      ^",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.err.Snippet(); got != tt.want {
+				t.Errorf("Snippet() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_InvalidRegexpNamesTerm(t *testing.T) {
+	_, err := Parse(`error and /(abc/`)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "/(abc/") {
+		t.Errorf("error %q does not mention the offending term", err)
+	}
+}
+
+func TestParse_MatchUnicode(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		line  string
+		want  bool
+	}{
+		"german":                       {query: "ÄRGER", line: "so ein ärger", want: true},
+		"cyrillic":                     {query: "ошибка", line: "ОШИБКА: нет соединения", want: true},
+		"greek final sigma":            {query: "ΟΔΟΣ", line: "οδος", want: true},
+		"greek sigma":                  {query: "ΣΟΦΙΑ", line: "σοφια", want: true},
+		"turkish dotless i":            {query: "ı", line: "I", want: false},
+		"kelvin sign":                  {query: "kelvin", line: "\u212Aelvin", want: true},
+		"long s":                       {query: "STOP", line: "ſtop", want: true},
+		"mixed with ascii":             {query: "Größe and error", line: "ERROR: GRÖßE", want: true},
+		"ascii query non-ascii line":   {query: "error", line: "Fehler: ERROR bei Größe", want: true},
+		"non-ascii query ascii line":   {query: "größe", line: "groesse", want: false},
+		"regexp keeps its own folding": {query: `/ärger/`, line: "ÄRGER", want: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.query, err)
+			}
+			l := &api.Log{Content: []byte(tt.line)}
+			if got := expr.Match(l); got != tt.want {
+				t.Errorf("Match(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+			// The fallback without the automaton must agree.
+			if got := expr.(*program).root.Match(l); got != tt.want {
+				t.Errorf("root.Match(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTermMatcher(t *testing.T) {
+	expr, err := Parse(`(timeout or Timeout or /code=5\d\d/) and not healthz or "db down"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewTermMatcher(expr.Terms())
+	tests := map[string]string{
+		"TIMEOUT code=503 db down": "timeout,/code=5\\d\\d/,db down",
+		"code=503":                 "/code=5\\d\\d/",
+		"db down after timeout":    "timeout,db down",
+		"nothing":                  "",
+	}
+	for line, want := range tests {
+		var got []string
+		for _, term := range m.Find([]byte(line)) {
+			got = append(got, term.String())
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("Find(%q) = %q, want %q", line, strings.Join(got, ","), want)
+		}
+	}
+}
+
+func TestHighlight_Unicode(t *testing.T) {
+	hl := func(s string) string {
+		return "\033[1;31m" + s + "\033[0m"
+	}
+	tests := map[string]struct {
+		line string
+		term string
+		want string
+	}{
+		"multibyte match": {
+			line: "Fehler: ÄRGER mit Größe",
+			term: "ärger",
+			want: "Fehler: " + hl("ÄRGER") + " mit Größe",
+		},
+		"adjacent multibyte matches": {
+			line: "ÄäÄ",
+			term: "ä",
+			want: hl("Ä") + hl("ä") + hl("Ä"),
+		},
+		"match straddles a character whose folding changes length": {
+			line: "x\u212Aelvin!",
+			term: "KELVIN",
+			want: "x" + hl("\u212Aelvin") + "!",
+		},
+		"match after a character whose folding changes length": {
+			line: "\u212A ошибка",
+			term: "ОШИБКА",
+			want: "\u212A " + hl("ошибка"),
+		},
+		"cyrillic": {
+			line: "Ошибка: ОШИБКА",
+			term: "ошибка",
+			want: hl("Ошибка") + ": " + hl("ОШИБКА"),
+		},
+		"invalid utf-8 is kept": {
+			line: "\xff error \xfe",
+			term: "ERROR",
+			want: "\xff " + hl("error") + " \xfe",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := NewHighlighter([]Term{newKeyword(tt.term)}).Highlight([]byte(tt.line))
+			if string(got) != tt.want {
+				t.Errorf("Highlight() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestTerms(t *testing.T) {
 	tests := map[string]struct {
 		query string
@@ -160,6 +782,26 @@ func TestTerms(t *testing.T) {
 			query: `"error code" and fatal`,
 			want:  []string{"error code", "fatal"},
 		},
+		"negated keyword excluded": {
+			query: "error and not healthcheck",
+			want:  []string{"error"},
+		},
+		"negated group excluded": {
+			query: "-(a or b) and c",
+			want:  []string{"c"},
+		},
+		"only negation": {
+			query: "not error",
+			want:  nil,
+		},
+		"field predicates excluded": {
+			query: "level=error and exists(trace_id) and container:app and timeout",
+			want:  []string{"timeout"},
+		},
+		"regexp": {
+			query: `/timeout after \d+ms/ or error`,
+			want:  []string{`/timeout after \d+ms/`, "error"},
+		},
 	}
 
 	for name, tt := range tests {
@@ -173,7 +815,7 @@ func TestTerms(t *testing.T) {
 				t.Fatalf("Terms() returned %d terms, want %d", len(got), len(tt.want))
 			}
 			for i, want := range tt.want {
-				if !bytes.Equal(got[i], []byte(want)) {
+				if got[i].String() != want {
 					t.Errorf("Terms()[%d] = %q, want %q", i, got[i], want)
 				}
 			}
@@ -185,10 +827,15 @@ func TestHighlight(t *testing.T) {
 	hl := func(s string) string {
 		return "\033[1;31m" + s + "\033[0m"
 	}
+	// hl2 is the color of the second term.
+	hl2 := func(s string) string {
+		return "\033[1;32m" + s + "\033[0m"
+	}
 
 	tests := map[string]struct {
 		line  string
 		terms []string
+		query string
 		want  string
 	}{
 		"no terms": {
@@ -209,7 +856,7 @@ func TestHighlight(t *testing.T) {
 		"multiple terms": {
 			line:  "fatal error occurred",
 			terms: []string{"fatal", "error"},
-			want:  hl("fatal") + " " + hl("error") + " occurred",
+			want:  hl("fatal") + " " + hl2("error") + " occurred",
 		},
 		"no match": {
 			line:  "all good",
@@ -219,7 +866,7 @@ func TestHighlight(t *testing.T) {
 		"overlapping prefers longer": {
 			line:  "error_code found",
 			terms: []string{"err", "error_code"},
-			want:  hl("error_code") + " found",
+			want:  hl2("error_code") + " found",
 		},
 		"multiple occurrences": {
 			line:  "error and error again",
@@ -241,18 +888,493 @@ func TestHighlight(t *testing.T) {
 			terms: []string{"error code"},
 			want:  "fatal " + hl("error code") + " 500",
 		},
+		"regexp match span": {
+			line:  "request timeout after 300ms, retrying",
+			query: `/timeout after \d+ms/`,
+			want:  "request " + hl("timeout after 300ms") + ", retrying",
+		},
+		"regexp multiple matches": {
+			line:  "status=503 then status=502",
+			query: `/status=5\d\d/`,
+			want:  hl("status=503") + " then " + hl("status=502"),
+		},
+		"regexp and keyword": {
+			line:  "error: took 12ms",
+			query: `/\d+ms/ and error`,
+			want:  hl2("error") + ": took " + hl("12ms"),
+		},
+		"regexp empty match ignored": {
+			line:  "bbb",
+			query: `/a*/`,
+			want:  "bbb",
+		},
+		"same color for repeated term": {
+			line:  "Error and error",
+			terms: []string{"error", "ERROR", "and"},
+			want:  hl("Error") + " " + hl2("and") + " " + hl("error"),
+		},
+		"palette wraps around": {
+			line:  "a b c d e f g h i j k l m",
+			terms: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m"},
+			want: "\033[1;31ma\033[0m \033[1;32mb\033[0m \033[1;33mc\033[0m \033[1;34md\033[0m " +
+				"\033[1;35me\033[0m \033[1;36mf\033[0m \033[1;91mg\033[0m \033[1;92mh\033[0m " +
+				"\033[1;93mi\033[0m \033[1;94mj\033[0m \033[1;95mk\033[0m \033[1;96ml\033[0m " +
+				"\033[1;31mm\033[0m",
+		},
+		"overlapping terms of different colors are not nested": {
+			line:  "request-id=abc123 failed",
+			terms: []string{"id=abc", "abc123 failed"},
+			want:  "request-" + hl("id=abc") + "123 failed",
+		},
+		"negated term not highlighted": {
+			line:  "error in healthcheck",
+			query: "error or not healthcheck",
+			want:  hl("error") + " in healthcheck",
+		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			var terms [][]byte
+			var terms []Term
 			for _, s := range tt.terms {
-				terms = append(terms, []byte(s))
+				terms = append(terms, newKeyword(s))
+			}
+			if len(tt.query) > 0 {
+				expr, err := Parse(tt.query)
+				if err != nil {
+					t.Fatalf("Parse(%q) error: %v", tt.query, err)
+				}
+				terms = append(terms, expr.Terms()...)
 			}
-			got := Highlight([]byte(tt.line), terms)
+			got := NewHighlighter(terms).Highlight([]byte(tt.line))
 			if !bytes.Equal(got, []byte(tt.want)) {
 				t.Errorf("Highlight() = %q, want %q", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestParseFile(t *testing.T) {
+	const file = `# Errors of the checkout service, without the noise.
+let NOISE = healthz or /metrics
+	or "connection reset by peer"  # retried by the client
+let FAILED = level=error or status>=500
+
+let CHECKOUT = container:checkout and $FAILED
+
+$CHECKOUT and not $NOISE
+`
+	expr, err := ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile() error: %v", err)
+	}
+	tests := map[string]struct {
+		log  *api.Log
+		want bool
+	}{
+		"failed": {
+			log:  &api.Log{Container: "checkout", Content: []byte(`{"level":"error","path":"/pay"}`)},
+			want: true,
+		},
+		"noise": {
+			log:  &api.Log{Container: "checkout", Content: []byte(`{"status":503,"path":"/healthz"}`)},
+			want: false,
+		},
+		"comment is not part of the query": {
+			log:  &api.Log{Container: "checkout", Content: []byte(`{"status":503,"msg":"retried by the client"}`)},
+			want: true,
+		},
+		"other container": {
+			log:  &api.Log{Container: "cart", Content: []byte(`{"level":"error"}`)},
+			want: false,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := expr.Match(tt.log); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var got []string
+	for _, term := range expr.Terms() {
+		got = append(got, term.String())
+	}
+	// Only predicates remain besides the negated macro.
+	if len(got) != 0 {
+		t.Errorf("Terms() = %v, want none", got)
+	}
+}
+
+func TestParseFile_WithoutMacros(t *testing.T) {
+	expr, err := ParseFile("# just a query\nerror\n  and timeout # trailing comment\n")
+	if err != nil {
+		t.Fatalf("ParseFile() error: %v", err)
+	}
+	if !expr.Match(&api.Log{Content: []byte("error: timeout")}) {
+		t.Error("expected match")
+	}
+}
+
+func TestParseFile_Errors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		wantPos int
+		wantMsg string
+	}{
+		"empty": {
+			content: "# nothing here\n",
+			wantPos: 15,
+			wantMsg: "missing query",
+		},
+		"only macros": {
+			content: "let A = error\n",
+			wantPos: 14,
+			wantMsg: "missing query",
+		},
+		"undefined macro": {
+			content: "let A = error\n$A and $B",
+			wantPos: 21,
+			wantMsg: "undefined macro $B",
+		},
+		"macro used before definition": {
+			content: "let A = $B\nlet B = error\n$A",
+			wantPos: 8,
+			wantMsg: "undefined macro $B",
+		},
+		"redefined macro": {
+			content: "let A = error\nlet A = warn\n$A",
+			wantPos: 14,
+			wantMsg: "macro $A is already defined",
+		},
+		"malformed definition": {
+			content: "let = error\nerror",
+			wantPos: 0,
+			wantMsg: "invalid macro definition",
+		},
+		"definition after query": {
+			content: "error\nlet A = warn",
+			wantPos: 6,
+			wantMsg: "unexpected macro definition",
+		},
+		"missing macro name": {
+			content: "error and $",
+			wantPos: 10,
+			wantMsg: "missing macro name",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseFile(tt.content)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("ParseFile() error = %v, want a *ParseError", err)
+			}
+			if pe.Pos != tt.wantPos || !strings.Contains(pe.Msg, tt.wantMsg) {
+				t.Errorf("ParseFile() error = %q at %d, want %q at %d", pe.Msg, pe.Pos, tt.wantMsg, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestParse_MacrosOnlyInFiles(t *testing.T) {
+	expr, err := Parse("$HOME or let")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if !expr.Match(&api.Log{Content: []byte("echo $HOME")}) {
+		t.Error("expected $HOME to be a keyword outside of query files")
+	}
+}
+
+func TestFormat_KeywordWithQuotes(t *testing.T) {
+	// Such a keyword cannot be written as a quoted keyword, and a bare word
+	// would start a quoted keyword.
+	k := newKeyword(`"not found" (ERR)`)
+	got := Format(k)
+	if want := `/"not found" \(ERR\)/`; got != want {
+		t.Fatalf("Format() = %q, want %q", got, want)
+	}
+	expr, err := Parse(got)
+	if err != nil {
+		t.Fatalf("Parse(%q) error: %v", got, err)
+	}
+	for _, line := range []string{`GET /x "NOT FOUND" (err)`, `not found (ERR)`} {
+		l := &api.Log{Content: []byte(line)}
+		if expr.Match(l) != k.Match(l) {
+			t.Errorf("Match(%q) = %v, want %v", line, expr.Match(l), k.Match(l))
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		want  string
+	}{
+		"and binds tighter":   {query: "a or b and c", want: "(a or (b and c))"},
+		"left associative":    {query: "a or b or c", want: "((a or b) or c)"},
+		"parentheses":         {query: "(a or b) and c", want: "((a or b) and c)"},
+		"not":                 {query: "-a and not (b or c)", want: "(not a and not (b or c))"},
+		"quoted keyword":      {query: `"error code" and "or"`, want: `("error code" and "or")`},
+		"keyword with colon":  {query: `"pod:"`, want: `"pod:"`},
+		"regexp":              {query: `/a\/b \d+/`, want: `/a\/b \d+/`},
+		"field":               {query: `msg="not found" and status>=500`, want: `(msg="not found" and status>=500)`},
+		"exists":              {query: "exists(trace_id)", want: "exists(trace_id)"},
+		"meta":                {query: `Pod:web-* or node:/^gpu-\d+$/`, want: `(pod:web-* or node:/^gpu-\d+$/)`},
+		"time":                {query: `after:"2026-10-18T10:00Z"`, want: "after:2026-10-18T10:00:00Z"},
+		"keyword is unquoted": {query: `"timeout"`, want: "timeout"},
+		"keyword with quote":  {query: `say"hi and foo"`, want: `(say"hi and foo")`},
+		"sequence":            {query: `reset or refused then panic and not "then" within 1m30s`, want: `(reset or refused) then (panic and not "then") within 1m30s`},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.query, err)
+			}
+			got := Format(expr)
+			if got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+			// The normalized form must parse to the same query.
+			again, err := Parse(got)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", got, err)
+			}
+			if Format(again) != got {
+				t.Errorf("Format(Parse(%q)) = %q", got, Format(again))
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	expr, err := Parse("a or b and -c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := Explain(&sb, expr, &api.Log{Content: []byte("b d")}); err != nil {
+		t.Fatal(err)
+	}
+	want := `+ (a or (b and not c))
+  - a
+  + (b and not c)
+    + b
+    + not c
+      - c
+`
+	if sb.String() != want {
+		t.Errorf("Explain() =\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	base := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	type line struct {
+		container string
+		offset    time.Duration
+		content   string
+	}
+	tests := map[string]struct {
+		query string
+		lines []line
+		// want are the groups of lines returned by Feed, as
+		// "container content" joined by "|".
+		want []string
+	}{
+		"pair with lines in between": {
+			query: `"connection reset" then panic within 10s`,
+			lines: []line{
+				{"app", 0, "starting"},
+				{"app", time.Second, "connection reset by peer"},
+				{"app", 2 * time.Second, "retrying"},
+				{"app", 3 * time.Second, "panic: nil map"},
+				{"app", 4 * time.Second, "panic: again"},
+			},
+			want: []string{"app connection reset by peer|app retrying|app panic: nil map"},
+		},
+		"window expired": {
+			query: `"connection reset" then panic within 10s`,
+			lines: []line{
+				{"app", 0, "connection reset by peer"},
+				{"app", 11 * time.Second, "panic: nil map"},
+			},
+		},
+		"window is inclusive": {
+			query: `reset then panic within 10s`,
+			lines: []line{
+				{"app", 0, "reset"},
+				{"app", 10 * time.Second, "panic"},
+			},
+			want: []string{"app reset|app panic"},
+		},
+		"per container": {
+			query: `reset then panic within 10s`,
+			lines: []line{
+				{"app", 0, "reset"},
+				{"sidecar", time.Second, "panic"},
+				{"sidecar", 2 * time.Second, "noise"},
+				{"app", 3 * time.Second, "panic"},
+			},
+			want: []string{"app reset|app panic"},
+		},
+		"oldest start within the window": {
+			query: `reset then panic within 10s`,
+			lines: []line{
+				{"app", 0, "reset 1"},
+				{"app", 5 * time.Second, "reset 2"},
+				{"app", 12 * time.Second, "reset 3"},
+				{"app", 14 * time.Second, "panic"},
+			},
+			want: []string{"app reset 2|app reset 3|app panic"},
+		},
+		"starts over after a match": {
+			query: `reset then panic within 10s`,
+			lines: []line{
+				{"app", 0, "reset"},
+				{"app", time.Second, "panic 1"},
+				{"app", 2 * time.Second, "panic 2"},
+				{"app", 3 * time.Second, "reset"},
+				{"app", 4 * time.Second, "panic 3"},
+			},
+			want: []string{"app reset|app panic 1", "app reset|app panic 3"},
+		},
+		"expressions": {
+			query: `level=error and (timeout or refused) then exit within 1m`,
+			lines: []line{
+				{"app", 0, "level=info msg=timeout"},
+				{"app", time.Second, "level=error msg=refused"},
+				{"app", 2 * time.Second, "exit 1"},
+			},
+			want: []string{"app level=error msg=refused|app exit 1"},
+		},
+		"same line does not complete its own sequence": {
+			query: `reset then panic within 10s`,
+			lines: []line{
+				{"app", 0, "panic after reset"},
+				{"app", time.Second, "panic"},
+			},
+			want: []string{"app panic after reset|app panic"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.query, err)
+			}
+			seq, ok := expr.(*Sequence)
+			if !ok {
+				t.Fatalf("Parse(%q) = %T, want *Sequence", tt.query, expr)
+			}
+			var got []string
+			for _, l := range tt.lines {
+				lines := seq.Feed(&api.Log{Pod: "web", Container: l.container, Content: []byte(l.content), Timestamp: base.Add(l.offset)})
+				if lines == nil {
+					continue
+				}
+				var group []string
+				for _, l := range lines {
+					group = append(group, l.Container+" "+string(l.Content))
+				}
+				got = append(got, strings.Join(group, "|"))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Feed() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFile_SequenceWithMacro(t *testing.T) {
+	// The macro is compiled into both programs of the sequence, whose
+	// keywords have different indexes.
+	expr, err := ParseFile("let A = foo\n$A then (bar and $A) within 10s")
+	if err != nil {
+		t.Fatalf("ParseFile() error: %v", err)
+	}
+	seq, ok := expr.(*Sequence)
+	if !ok {
+		t.Fatalf("ParseFile() = %T, want *Sequence", expr)
+	}
+	base := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	lines := []string{"foo", "bar", "bar foo"}
+	var got []*api.Log
+	for idx, content := range lines {
+		got = seq.Feed(&api.Log{Pod: "web", Container: "app", Content: []byte(content), Timestamp: base.Add(time.Duration(idx) * time.Second)})
+	}
+	if len(got) != 3 {
+		t.Errorf("Feed() = %d lines, want the whole sequence", len(got))
+	}
+}
+
+func TestSequence_BoundedState(t *testing.T) {
+	expr, err := Parse("reset then panic within 10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq := expr.(*Sequence)
+	base := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	feed := func(container string, offset time.Duration, content string) []*api.Log {
+		return seq.Feed(&api.Log{Container: container, Content: []byte(content), Timestamp: base.Add(offset)})
+	}
+
+	// The lines of a start are given up once there are too many of them.
+	feed("app", 0, "reset")
+	for i := range maxSequenceLines {
+		feed("app", time.Millisecond*time.Duration(i), "noise")
+	}
+	if n := len(seq.streams); n != 0 {
+		t.Errorf("%d streams are kept after too many lines, want 0", n)
+	}
+	if lines := feed("app", 2*time.Second, "panic"); lines != nil {
+		t.Errorf("Feed() = %d lines after the start was given up, want nil", len(lines))
+	}
+
+	// Containers which stop logging are swept by the lines of others.
+	feed("quiet", 3*time.Second, "reset")
+	feed("busy", 4*time.Second, "reset")
+	feed("busy", 15*time.Second, "noise")
+	if _, ok := seq.streams["//quiet"]; ok {
+		t.Error("state of an expired container is kept")
+	}
+	if n := len(seq.streams); n != 0 {
+		t.Errorf("%d streams are kept after they expired, want 0", n)
+	}
+}
+
+func TestParse_SequenceErrors(t *testing.T) {
+	tests := map[string]struct {
+		query   string
+		wantMsg string
+		// wantPos is the position of the error, if it is checked.
+		wantPos int
+	}{
+		"missing within":   {query: "a then b", wantMsg: `missing time window of "then"`},
+		"missing duration": {query: "a then b within", wantMsg: `missing duration after "within"`},
+		"invalid duration": {query: "a then b within soon", wantMsg: `invalid duration "soon"`},
+		"negative":         {query: "a then b within -1s", wantMsg: `invalid duration "-1s"`, wantPos: 16},
+		"chained":          {query: "a then b then c within 1s", wantMsg: `only two expressions may be joined with "then"`},
+		"nested":           {query: "(a then b within 1s)", wantMsg: `unexpected "then"`},
+		"dangling then":    {query: "a then", wantMsg: "unexpected end of query"},
+		"trailing":         {query: "a then b within 1s c", wantMsg: `unexpected "c"`},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse(%q) error = %v, want *ParseError", tt.query, err)
+			}
+			if pe.Msg != tt.wantMsg {
+				t.Errorf("Parse(%q) message = %q, want %q", tt.query, pe.Msg, tt.wantMsg)
+			}
+			if tt.wantPos > 0 && pe.Pos != tt.wantPos {
+				t.Errorf("Parse(%q) position = %d, want %d", tt.query, pe.Pos, tt.wantPos)
+			}
+		})
+	}
+}
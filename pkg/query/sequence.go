@@ -0,0 +1,160 @@
+package query
+
+import (
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// maxSequenceLines is the maximum number of lines buffered for a container
+// while waiting for the end of a sequence. Once it is exceeded, the oldest
+// start of the sequence is given up.
+const maxSequenceLines = 1000
+
+// thenExpr is the parsed form of A then B within D. It only occurs as the root
+// of a query and is turned into a Sequence by compileQuery.
+type thenExpr struct {
+	first, then node
+	within      time.Duration
+}
+
+func (t *thenExpr) Match(l *api.Log) bool {
+	return t.then.Match(l)
+}
+
+func (t *thenExpr) eval(l *api.Log, found []bool) bool {
+	return t.then.eval(l, found)
+}
+
+func (t *thenExpr) Terms() []Term {
+	return append(t.first.Terms(), t.then.Terms()...)
+}
+
+// Sequence is a query of the form A then B within D, which matches a line
+// matching B if a line matching A was logged at most D earlier by the same
+// container. Unlike other queries it is stateful, so lines must be given to
+// it in the order they are logged.
+type Sequence struct {
+	first, then *program
+	within      time.Duration
+
+	streams map[string]*sequenceState
+	// lastSweep is when the state of all streams was last expired.
+	lastSweep time.Time
+}
+
+// sequenceState holds the lines of a container since the oldest pending start
+// of a sequence.
+type sequenceState struct {
+	lines []*api.Log
+	// starts are the lines matching A which have not expired yet.
+	starts []sequenceStart
+}
+
+type sequenceStart struct {
+	// index is the index of the line in lines.
+	index int
+	at    time.Time
+}
+
+func newSequence(first, then *program, within time.Duration) *Sequence {
+	return &Sequence{
+		first:   first,
+		then:    then,
+		within:  within,
+		streams: make(map[string]*sequenceState),
+	}
+}
+
+// Match reports whether l completes a sequence, see Feed.
+func (s *Sequence) Match(l *api.Log) bool {
+	return s.Feed(l) != nil
+}
+
+func (s *Sequence) Terms() []Term {
+	return append(s.first.Terms(), s.then.Terms()...)
+}
+
+// Feed records l. If l completes a sequence, it returns the lines of the
+// container from the oldest line matching A within the window up to l, and
+// starts over for the container. Otherwise it returns nil.
+//
+// Lines without a timestamp are taken as logged now.
+func (s *Sequence) Feed(l *api.Log) []*api.Log {
+	now := timestampOf(l)
+	if now.Sub(s.lastSweep) > s.within {
+		s.sweep(now)
+	}
+
+	key := l.Stream()
+	st := s.streams[key]
+	if st != nil {
+		st.expire(now.Add(-s.within))
+		if len(st.starts) == 0 {
+			delete(s.streams, key)
+			st = nil
+		}
+	}
+	if st != nil && s.then.Match(l) {
+		delete(s.streams, key)
+		return append(st.lines, l)
+	}
+	if s.first.Match(l) {
+		if st == nil {
+			st = &sequenceState{}
+			s.streams[key] = st
+		}
+		st.starts = append(st.starts, sequenceStart{index: len(st.lines), at: now})
+	}
+	if st != nil {
+		st.lines = append(st.lines, l)
+		if len(st.lines) > maxSequenceLines {
+			st.dropFirst()
+			if len(st.starts) == 0 {
+				delete(s.streams, key)
+			}
+		}
+	}
+	return nil
+}
+
+// sweep drops the state of the containers whose sequences have all expired,
+// so that containers which stop logging do not hold on to their lines.
+func (s *Sequence) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, st := range s.streams {
+		st.expire(now.Add(-s.within))
+		if len(st.starts) == 0 {
+			delete(s.streams, key)
+		}
+	}
+}
+
+func timestampOf(l *api.Log) time.Time {
+	if l.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return l.Timestamp
+}
+
+// expire gives up the starts of sequences logged before deadline.
+func (st *sequenceState) expire(deadline time.Time) {
+	for len(st.starts) > 0 && st.starts[0].at.Before(deadline) {
+		st.dropFirst()
+	}
+}
+
+// dropFirst gives up the oldest start of a sequence, together with the lines
+// before the next one.
+func (st *sequenceState) dropFirst() {
+	st.starts = st.starts[1:]
+	if len(st.starts) == 0 {
+		st.lines = nil
+		return
+	}
+	offset := st.starts[0].index
+	st.lines = append(st.lines[:0:0], st.lines[offset:]...)
+	for i := range st.starts {
+		st.starts[i].index -= offset
+	}
+}
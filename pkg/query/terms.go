@@ -0,0 +1,85 @@
+package query
+
+// termSet holds the distinct terms of a set, in the order they are given.
+// Keywords which are the same under case folding, and other terms which are
+// written the same, count once. Keywords are all located in a single pass by
+// an automaton.
+type termSet struct {
+	terms []Term
+	ac    *automaton
+	// keywords holds the index in terms of each pattern of ac.
+	keywords []int
+	// others holds the regular expressions, with their index in terms.
+	others       []*regexpTerm
+	otherIndexes []int
+}
+
+func newTermSet(terms []Term) termSet {
+	var s termSet
+	var patterns [][]byte
+	seen := make(map[string]struct{})
+	for _, t := range terms {
+		k, isKeyword := t.(*keyword)
+		if isKeyword && len(k.term) == 0 {
+			continue
+		}
+		key := t.String()
+		if isKeyword {
+			key = string(fold(k.term))
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if isKeyword {
+			patterns = append(patterns, k.term)
+			s.keywords = append(s.keywords, len(s.terms))
+		} else {
+			s.others = append(s.others, t.(*regexpTerm))
+			s.otherIndexes = append(s.otherIndexes, len(s.terms))
+		}
+		s.terms = append(s.terms, t)
+	}
+	if len(patterns) > 0 {
+		s.ac = newAutomaton(patterns)
+	}
+	return s
+}
+
+// TermMatcher reports which of a set of terms occur in a line. Like
+// Highlighter, keywords are all located in a single pass by an automaton.
+type TermMatcher struct {
+	termSet
+}
+
+// NewTermMatcher creates a TermMatcher of terms. Keywords which are the same
+// under case folding, and other terms which are written the same, are only
+// reported once.
+func NewTermMatcher(terms []Term) *TermMatcher {
+	return &TermMatcher{termSet: newTermSet(terms)}
+}
+
+// Find returns the terms which occur in line, in the order they were given.
+func (m *TermMatcher) Find(line []byte) []Term {
+	found := make([]bool, len(m.terms))
+	if m.ac != nil {
+		remaining := len(m.keywords)
+		m.ac.scan(line, func(pattern, _, _ int) bool {
+			if idx := m.keywords[pattern]; !found[idx] {
+				found[idx] = true
+				remaining--
+			}
+			return remaining > 0
+		})
+	}
+	for i, r := range m.others {
+		found[m.otherIndexes[i]] = len(r.findAllIndex(line)) > 0
+	}
+	var terms []Term
+	for idx, ok := range found {
+		if ok {
+			terms = append(terms, m.terms[idx])
+		}
+	}
+	return terms
+}
@@ -0,0 +1,69 @@
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/knight42/kt/pkg/api"
+)
+
+// timeLayouts are the formats accepted by after: and before:, tried in order.
+// Times without a zone are in local time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timeExpr matches logs recorded at or after a time, or before it if before is
+// true, so that after:T1 and before:T2 selects the window [T1, T2). Logs
+// without a timestamp never match.
+type timeExpr struct {
+	before bool
+	t      time.Time
+}
+
+func (e *timeExpr) Match(l *api.Log) bool {
+	if l.Timestamp.IsZero() {
+		return false
+	}
+	if e.before {
+		return l.Timestamp.Before(e.t)
+	}
+	return !l.Timestamp.Before(e.t)
+}
+
+func (e *timeExpr) eval(l *api.Log, _ []bool) bool {
+	return e.Match(l)
+}
+
+func (e *timeExpr) Terms() []Term {
+	return nil
+}
+
+// timePrefix returns "after" or "before" if word starts with that qualifier,
+// or an empty string otherwise.
+func timePrefix(word string) string {
+	name, _, found := strings.Cut(word, ":")
+	if !found {
+		return ""
+	}
+	name = strings.ToLower(name)
+	if name != "after" && name != "before" {
+		return ""
+	}
+	return name
+}
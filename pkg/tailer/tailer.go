@@ -2,8 +2,11 @@ package tailer
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"io"
+	"sync/atomic"
+	"time"
 
 	"github.com/fatih/color"
 	corev1 "k8s.io/api/core/v1"
@@ -16,12 +19,18 @@ import (
 type Tailer interface {
 	Tail()
 	RetryContainers(names []string)
+	// SetPod updates the metadata of the pod carried by its logs, e.g. its
+	// node once it is scheduled.
+	SetPod(pod *corev1.Pod)
 	ContainerCount() int
 	Close()
 }
 
+// New creates a Tailer of the containers ctNames of pod. revision is the
+// revision of the Deployment of the pod, if it is known.
 func New(
-	ns, name string,
+	pod *corev1.Pod,
+	revision string,
 	ctNames map[string]struct{},
 	enableColor bool,
 	client kubernetes.Interface,
@@ -33,10 +42,11 @@ func New(
 	if enableColor {
 		podColor, ctColor = pickColor()
 	}
-	return &tailer{
+	t := &tailer{
 		client:      client,
-		namespace:   ns,
-		podName:     name,
+		namespace:   pod.Namespace,
+		podName:     pod.Name,
+		revision:    revision,
 		ctNames:     ctNames,
 		logsOptions: logsOptions,
 		logCh:       logCh,
@@ -47,12 +57,23 @@ func New(
 		podColor: podColor,
 		ctColor:  ctColor,
 	}
+	t.SetPod(pod)
+	return t
+}
+
+// podMeta is the metadata of a pod which may change while it is tailed.
+type podMeta struct {
+	nodeName string
+	labels   map[string]string
 }
 
 type tailer struct {
-	client      kubernetes.Interface
-	namespace   string
-	podName     string
+	client    kubernetes.Interface
+	namespace string
+	podName   string
+	// meta is read by the tasks while SetPod replaces it.
+	meta        atomic.Pointer[podMeta]
+	revision    string
 	ctNames     map[string]struct{}
 	logsOptions *corev1.PodLogOptions
 	logCh       chan<- *api.Log
@@ -75,7 +96,10 @@ func (t *tailer) Tail() {
 func (t *tailer) fetchLog(ctx context.Context, container string) error {
 	opt := t.logsOptions.DeepCopy()
 	opt.Container = container
-	stream, err := t.client.CoreV1().Pods(t.namespace).GetLogs(t.podName, opt).Stream(context.Background())
+	// Timestamps are always requested so that every log carries one, and
+	// the controller prints them only if the user asked for them.
+	opt.Timestamps = true
+	stream, err := t.client.CoreV1().Pods(t.namespace).GetLogs(t.podName, opt).Stream(ctx)
 	if err != nil {
 		return err
 	}
@@ -88,23 +112,56 @@ func (t *tailer) fetchLog(ctx context.Context, container string) error {
 			return nil
 		default:
 		}
-		bytes, err := r.ReadBytes('\n')
+		line, err := r.ReadBytes('\n')
 		if err != nil {
-			if err == io.EOF {
+			// The stream is aborted once the tailer is closed.
+			if err == io.EOF || ctx.Err() != nil {
 				return nil
 			}
 			return err
 		}
-		t.logCh <- &api.Log{
+		ts, content := splitTimestamp(line)
+		meta := t.meta.Load()
+		l := &api.Log{
+			Namespace:      t.namespace,
 			Pod:            t.podName,
 			Container:      container,
-			Content:        bytes,
+			Node:           meta.nodeName,
+			Labels:         meta.labels,
+			Revision:       t.revision,
+			Content:        content,
+			Timestamp:      ts,
 			PodColor:       t.podColor,
 			ContainerColor: t.ctColor,
 		}
+		// Do not block on a consumer which is gone once the tailer is closed.
+		select {
+		case t.logCh <- l:
+		case <-stopCh:
+			return nil
+		}
 	}
 }
 
+// splitTimestamp splits the RFC 3339 timestamp prepended by the API server off
+// line. If line does not start with a timestamp, it is returned as is with a
+// zero time.
+func splitTimestamp(line []byte) (time.Time, []byte) {
+	sp := bytes.IndexByte(line, ' ')
+	if sp < 0 {
+		return time.Time{}, line
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(line[:sp]))
+	if err != nil {
+		return time.Time{}, line
+	}
+	return ts, line[sp+1:]
+}
+
+func (t *tailer) SetPod(pod *corev1.Pod) {
+	t.meta.Store(&podMeta{nodeName: pod.Spec.NodeName, labels: pod.Labels})
+}
+
 func (t *tailer) RetryContainers(names []string) {
 	if len(names) == 0 {
 		return
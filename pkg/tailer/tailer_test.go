@@ -0,0 +1,44 @@
+package tailer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitTimestamp(t *testing.T) {
+	tests := map[string]struct {
+		line        string
+		wantTime    time.Time
+		wantContent string
+	}{
+		"timestamp": {
+			line:        "2026-10-18T10:00:00.123456789Z hello world\n",
+			wantTime:    time.Date(2026, 10, 18, 10, 0, 0, 123456789, time.UTC),
+			wantContent: "hello world\n",
+		},
+		"empty line": {
+			line:        "2026-10-18T10:00:00Z \n",
+			wantTime:    time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+			wantContent: "\n",
+		},
+		"no timestamp": {
+			line:        "hello world\n",
+			wantContent: "hello world\n",
+		},
+		"no space": {
+			line:        "2026-10-18T10:00:00Z",
+			wantContent: "2026-10-18T10:00:00Z",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotTime, gotContent := splitTimestamp([]byte(tt.line))
+			if !gotTime.Equal(tt.wantTime) {
+				t.Errorf("splitTimestamp() time = %v, want %v", gotTime, tt.wantTime)
+			}
+			if string(gotContent) != tt.wantContent {
+				t.Errorf("splitTimestamp() content = %q, want %q", gotContent, tt.wantContent)
+			}
+		})
+	}
+}